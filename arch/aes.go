@@ -0,0 +1,294 @@
+package arch
+
+import (
+	"archive/zip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"hash"
+	"io"
+)
+
+// WinZip AES encryption, see https://www.winzip.com/en/support/aes-encryption/
+const (
+	// methodAES is the method of entries encrypted with AES,
+	// the actual method is kept in the AES extra field
+	methodAES = 99
+	// aesExtraID is the ID of the AES extra field
+	aesExtraID = 0x9901
+	// aesExtraSize is the size of the AES extra field with its header
+	aesExtraSize = 4 + 7
+	// zipVersion51 is the version needed to extract AES entries
+	zipVersion51 = 51
+
+	// aesVersion2 is AE-2, which doesn't keep CRC of entries,
+	// as the authentication code verifies them
+	aesVersion2 = 2
+	// aesStrength256 is the key strength of AES-256
+	aesStrength256 = 3
+
+	pbkdf2Iterations = 1000
+	// aesVerifierSize is the size of the password verification value
+	aesVerifierSize = 2
+	// aesMACSize is the size of the authentication code,
+	// the first bytes of HMAC-SHA1 of the encrypted data
+	aesMACSize = 10
+	// aesOverhead is the size the data of an entry grows by when encrypted
+	// with AES-256: salt, password verification value and authentication code
+	aesOverhead = 16 + aesVerifierSize + aesMACSize
+)
+
+// aesExtra is the AES extra field of an entry
+type aesExtra struct {
+	version  uint16
+	strength byte
+	// method is the actual compression method of the entry
+	method uint16
+}
+
+// keySize returns the size of AES keys of the strength, 0 if it is unknown
+func (e aesExtra) keySize() int {
+	switch e.strength {
+	case 1:
+		return 16
+	case 2:
+		return 24
+	case aesStrength256:
+		return 32
+	}
+	return 0
+}
+
+// saltSize is half the key size
+func (e aesExtra) saltSize() int {
+	return e.keySize() / 2
+}
+
+func (e aesExtra) bytes() []byte {
+	extra := make([]byte, aesExtraSize)
+	binary.LittleEndian.PutUint16(extra[0:], aesExtraID)
+	binary.LittleEndian.PutUint16(extra[2:], aesExtraSize-4)
+	binary.LittleEndian.PutUint16(extra[4:], e.version)
+	copy(extra[6:], "AE")
+	extra[8] = e.strength
+	binary.LittleEndian.PutUint16(extra[9:], e.method)
+	return extra
+}
+
+// parseAESExtra finds the AES extra field in the extra fields of an entry
+func parseAESExtra(extra []byte) (aesExtra, bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:])
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		if id == aesExtraID && size >= 7 && string(extra[6:8]) == "AE" {
+			return aesExtra{
+				version:  binary.LittleEndian.Uint16(extra[4:]),
+				strength: extra[8],
+				method:   binary.LittleEndian.Uint16(extra[9:]),
+			}, true
+		}
+		extra = extra[4+size:]
+	}
+	return aesExtra{}, false
+}
+
+// aesKeys are the keys derived from the password and the salt of an entry
+type aesKeys struct {
+	encryption []byte
+	mac        []byte
+	verifier   []byte
+}
+
+func deriveAESKeys(password string, salt []byte, keySize int) aesKeys {
+	key := pbkdf2SHA1([]byte(password), salt, pbkdf2Iterations, 2*keySize+aesVerifierSize)
+	return aesKeys{
+		encryption: key[:keySize],
+		mac:        key[keySize : 2*keySize],
+		verifier:   key[2*keySize:],
+	}
+}
+
+// pbkdf2SHA1 derives a key of keyLen bytes from the password
+// with PBKDF2 using HMAC-SHA1, as defined by RFC 8018
+func pbkdf2SHA1(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha1.New, password)
+	key := make([]byte, 0, keyLen+sha1.Size)
+	u := make([]byte, sha1.Size)
+	t := make([]byte, sha1.Size)
+	var counter [4]byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		binary.BigEndian.PutUint32(counter[:], block)
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(counter[:])
+		u = prf.Sum(u[:0])
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// aesCTR is AES in counter mode as WinZip uses it: the counter is
+// a little-endian number starting at 1, unlike the one of cipher.NewCTR
+type aesCTR struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	// pos is the position of the next byte of stream to be used
+	pos int
+}
+
+func newAESCTR(key []byte) (*aesCTR, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &aesCTR{block: block, pos: aes.BlockSize}, nil
+}
+
+func (c *aesCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.pos == aes.BlockSize {
+			for j := range c.counter {
+				c.counter[j]++
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+			c.block.Encrypt(c.stream[:], c.counter[:])
+			c.pos = 0
+		}
+		dst[i] = src[i] ^ c.stream[c.pos]
+		c.pos++
+	}
+}
+
+// aesWriter encrypts data of an entry with AES-256 writing it to w
+// preceded by the salt and the password verification value.
+// Close writes the authentication code
+type aesWriter struct {
+	w   io.Writer
+	ctr *aesCTR
+	mac hash.Hash
+	buf []byte
+}
+
+func newAESWriter(w io.Writer, password string) (*aesWriter, error) {
+	extra := aesExtra{strength: aesStrength256}
+	salt := make([]byte, extra.saltSize())
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	keys := deriveAESKeys(password, salt, extra.keySize())
+	ctr, err := newAESCTR(keys.encryption)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(keys.verifier); err != nil {
+		return nil, err
+	}
+	return &aesWriter{w: w, ctr: ctr, mac: hmac.New(sha1.New, keys.mac)}, nil
+}
+
+func (aw *aesWriter) Write(p []byte) (int, error) {
+	if cap(aw.buf) < len(p) {
+		aw.buf = make([]byte, len(p))
+	}
+	buf := aw.buf[:len(p)]
+	aw.ctr.XORKeyStream(buf, p)
+	aw.mac.Write(buf)
+	return aw.w.Write(buf)
+}
+
+func (aw *aesWriter) Close() error {
+	_, err := aw.w.Write(aw.mac.Sum(nil)[:aesMACSize])
+	return err
+}
+
+// aesReader decrypts data of an entry checking the authentication code
+// once all of it is read
+type aesReader struct {
+	r        io.Reader
+	ctr      *aesCTR
+	mac      hash.Hash
+	expected io.Reader
+	archive  string
+	name     string
+	// checked is set once the authentication code is checked
+	checked bool
+}
+
+// newAESReader returns a reader of the compressed data of entry f
+// read from raw. It fails with ErrWrongPassword if the password doesn't
+// match the password verification value
+func newAESReader(archiveName string, f *zip.File, extra aesExtra, raw io.Reader, password string) (io.Reader, error) {
+	keySize := extra.keySize()
+	if keySize == 0 {
+		return nil, newError(ErrCorruptArchive, archiveName, f.Name, nil,
+			"unknown AES strength %d of entry %s", extra.strength, f.Name)
+	}
+	header := make([]byte, extra.saltSize()+aesVerifierSize)
+	dataSize := int64(f.CompressedSize64) - int64(len(header)) - aesMACSize
+	if dataSize < 0 {
+		return nil, newError(ErrCorruptArchive, archiveName, f.Name, nil,
+			"encrypted entry %s is truncated", f.Name)
+	}
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, newError(ErrCorruptArchive, archiveName, f.Name, err,
+			"unable to read encrypted entry %s", f.Name)
+	}
+	keys := deriveAESKeys(password, header[:extra.saltSize()], keySize)
+	if subtle.ConstantTimeCompare(keys.verifier, header[extra.saltSize():]) != 1 {
+		return nil, newError(ErrWrongPassword, archiveName, f.Name, nil,
+			"wrong password for entry %s", f.Name)
+	}
+	ctr, err := newAESCTR(keys.encryption)
+	if err != nil {
+		return nil, newError(ErrInternal, archiveName, f.Name, err, "unable to decrypt entry %s", f.Name)
+	}
+	return &aesReader{
+		r:        io.LimitReader(raw, dataSize),
+		ctr:      ctr,
+		mac:      hmac.New(sha1.New, keys.mac),
+		expected: raw,
+		archive:  archiveName,
+		name:     f.Name,
+	}, nil
+}
+
+func (ar *aesReader) Read(p []byte) (int, error) {
+	n, err := ar.r.Read(p)
+	ar.mac.Write(p[:n])
+	ar.ctr.XORKeyStream(p[:n], p[:n])
+	if err == io.EOF && !ar.checked {
+		ar.checked = true
+		expected := make([]byte, aesMACSize)
+		if _, readErr := io.ReadFull(ar.expected, expected); readErr != nil {
+			return n, newError(ErrCorruptArchive, ar.archive, ar.name, readErr,
+				"unable to read authentication code of entry %s", ar.name)
+		}
+		if !hmac.Equal(ar.mac.Sum(nil)[:aesMACSize], expected) {
+			return n, newError(ErrCorruptArchive, ar.archive, ar.name, nil,
+				"authentication of entry %s failed", ar.name)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,75 @@
+package arch
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// newFileMode is the mode of files created by replacing temporary files,
+// which are created with mode 0600
+const newFileMode = 0644
+
+// atomicFile is a temporary file next to the target file,
+// which replaces the target once committed. So the target is either
+// left as it was or is replaced by the complete file
+type atomicFile struct {
+	*os.File
+	// target is the file replaced on commit
+	target    string
+	committed bool
+}
+
+// createAtomic creates a temporary file to replace target with
+func createAtomic(target string) (*atomicFile, error) {
+	file, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return nil, newError(ErrInvalidPath, target, "", err,
+			"unable to create file %s", target)
+	}
+	return &atomicFile{File: file, target: target}, nil
+}
+
+// commit flushes the file to disk and renames it to the target,
+// keeping permissions of the target if it exists
+func (f *atomicFile) commit() error {
+	var mode os.FileMode = newFileMode
+	if info, err := os.Stat(f.target); err == nil {
+		mode = info.Mode().Perm()
+	}
+	err := f.Chmod(mode)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return newError(ErrInternal, f.target, "", err, "unable to write file %s", f.target)
+	}
+	if err := os.Rename(f.Name(), f.target); err != nil {
+		return newError(ErrInternal, f.target, "", err, "unable to replace file %s", f.target)
+	}
+	f.committed = true
+	syncDir(filepath.Dir(f.target))
+	return nil
+}
+
+// discard removes the temporary file unless it is committed
+func (f *atomicFile) discard() {
+	if f.committed {
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// syncDir flushes the directory entry of a renamed file to disk.
+// Not every file system supports it, so errors are ignored
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	d.Sync()
+}
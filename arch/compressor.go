@@ -2,107 +2,404 @@ package arch
 
 import (
 	"archive/zip"
-	"fmt"
+	"context"
 	"io"
 	"io/fs"
-	"net/http"
 	"os"
 	"path/filepath"
-	"sort"
+	"time"
 )
 
-const defaultMaxFiles = 10
+const (
+	defaultMaxFiles = 10
+	// sampleSize is the number of bytes compressed to estimate compression ratio
+	sampleSize = 64 * 1024
+)
 
-type Request struct {
-	ArchiveName string `json:"file"`
-	Directory   string `json:"dir"`
-	Filter      string `json:"filter,omitempty"`
-	Limit       int    `json:"limit,omitempty"`
+// candidate is a file selected to be compressed
+type candidate struct {
+	path string
+	// rel is the slash separated path relative to the source directory
+	rel string
+	// name is the name of the entry in the archive
+	name string
+	info fs.FileInfo
 }
 
-func Compress(req Request) (int, error) {
+// Compress creates archive req.ArchiveName from files in req.Directory
+// and req.Sources. If req.Sign is set, the archive is signed with req.SigningKey.
+// It stops with ErrCanceled when ctx is done. Returned errors are of type *Error
+func Compress(ctx context.Context, req Request) (Result, error) {
+	start := time.Now()
+	var res Result
+
+	comp, err := newCompression(req)
+	if err != nil {
+		return res, err
+	}
+	if err := checkSign(req); err != nil {
+		return res, err
+	}
+	inc, err := newIncremental(req)
+	if err != nil {
+		return res, err
+	}
+	old, err := openExisting(req)
+	if err != nil {
+		return res, err
+	}
+	if old != nil {
+		defer old.Close()
+	}
+	selected, skipped, err := selectFiles(req, inc, old)
+	if err != nil {
+		return res, err
+	}
+	res.Skipped = skipped
+	var manifest *Manifest
+	if inc != nil {
+		manifest = inc.manifest()
+		res.Deleted = manifest.Deleted
+	} else if req.Manifest {
+		manifest = newManifest()
+	}
+
+	budget := newArchiveBudget(req.MaxArchiveBytes)
+	var kept []*zip.File
+	if old != nil {
+		kept = old.kept()
+		for _, f := range kept {
+			budget.add(f.Name, "", int64(f.CompressedSize64))
+		}
+		res.Kept = len(kept)
+		if manifest != nil {
+			for _, f := range kept {
+				manifest.Files = append(manifest.Files, ManifestFile{
+					Name:     f.Name,
+					Size:     int64(f.UncompressedSize64),
+					Modified: f.Modified,
+					CRC32:    f.CRC32,
+				})
+			}
+		}
+	}
+	if manifest != nil {
+		if err := budget.reserveManifest(manifest); err != nil {
+			return res, err
+		}
+	}
+
+	if req.DryRun {
+		err = planFiles(ctx, &res, selected, comp, budget)
+		res.Elapsed = time.Since(start)
+		return res, err
+	}
+
 	archDir := filepath.Dir(req.ArchiveName)
-	err := os.MkdirAll(archDir, os.ModePerm)
+	err = os.MkdirAll(archDir, os.ModePerm)
 	if err != nil {
-		return http.StatusBadRequest,
-			fmt.Errorf("unable to create parent directory for archive (%w)", err)
+		return res, newError(ErrInvalidPath, archDir, "", err,
+			"unable to create parent directory for archive")
 	}
 
-	archiveFile, err := os.Create(req.ArchiveName)
+	// the archive is written next to req.ArchiveName
+	// and replaces it once complete
+	archiveFile, err := createAtomic(req.ArchiveName)
 	if err != nil {
-		return http.StatusBadRequest,
-			fmt.Errorf("unable to create archive file (%w)", err)
+		return res, err
 	}
-	defer archiveFile.Close()
+	defer archiveFile.discard()
 	writer := zip.NewWriter(archiveFile)
-	defer writer.Close()
+	if req.Sign == SignComment {
+		if err := writer.SetComment(signaturePlaceholder()); err != nil {
+			return res, newError(ErrInternal, req.ArchiveName, "", err,
+				"unable to sign archive %s", req.ArchiveName)
+		}
+	}
 
-	// room for optimisation
-	files, err := os.ReadDir(req.Directory)
+	for _, f := range kept {
+		if err := writer.Copy(f); err != nil {
+			return res, newError(ErrCorruptArchive, req.ArchiveName, f.Name, err,
+				"unable to copy entry %s of archive %s", f.Name, req.ArchiveName)
+		}
+	}
+	err = compressFiles(ctx, selected, comp, req.Concurrency, func(i int, entry *compressedEntry) (bool, error) {
+		header := entry.header
+		if !budget.fits(header.Name, selected[i].path, int64(header.CompressedSize64)) {
+			entry.data.Close()
+			res.Skipped = append(res.Skipped, skipAll(selected[i:], SkipBudget)...)
+			return false, nil
+		}
+		budget.add(header.Name, selected[i].path, int64(header.CompressedSize64))
+		if err := entry.write(writer); err != nil {
+			return false, err
+		}
+		res.add(Entry{
+			Name:           header.Name,
+			Path:           selected[i].path,
+			Size:           int64(header.UncompressedSize64),
+			CompressedSize: int64(header.CompressedSize64),
+			Method:         methodNames[entry.method],
+			Encrypted:      comp.password != "",
+		})
+		if manifest != nil {
+			manifest.Files = append(manifest.Files, ManifestFile{
+				Name:     header.Name,
+				Path:     selected[i].path,
+				Size:     int64(header.UncompressedSize64),
+				Modified: selected[i].info.ModTime(),
+				CRC32:    entry.crc32,
+			})
+		}
+		return true, nil
+	})
 	if err != nil {
-		return http.StatusBadRequest,
-			fmt.Errorf("unable to list directory %s (%w)", req.Directory, err)
+		return res, err
 	}
-	fileInfos := make([]fs.FileInfo, 0, len(files))
-	for _, entry := range files {
-		if entry.IsDir() {
-			continue
+	if manifest != nil {
+		if err := manifest.write(writer); err != nil {
+			return res, err
 		}
-		info, err := entry.Info()
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return res, newError(ErrInternal, req.ArchiveName, "", err,
+			"unable to finish archive %s", req.ArchiveName)
+	}
+	var sigFile *atomicFile
+	if req.Sign != "" {
+		sigFile, err = signArchive(archiveFile, req.Sign, req.SigningKey)
 		if err != nil {
-			return http.StatusInternalServerError,
-				fmt.Errorf("error reading info for file %s (%w)", entry.Name(), err)
+			return res, err
 		}
-		fileInfos = append(fileInfos, info)
+		if sigFile != nil {
+			defer sigFile.discard()
+		}
+		res.Signed = true
+	}
+	if old != nil {
+		old.Close()
+	}
+	if err := archiveFile.commit(); err != nil {
+		return res, err
+	}
+	switch req.Sign {
+	case SignDetached:
+		if err := sigFile.commit(); err != nil {
+			return res, err
+		}
+	case SignComment:
+		// a detached signature of the replaced archive would be checked first
+		os.Remove(req.ArchiveName + SignatureExt)
+	}
+	res.Elapsed = time.Since(start)
+	bytesCompressed.Add(float64(res.Bytes))
+	filesProcessed.Add(float64(res.Files), opCompress)
+
+	return res, nil
+}
+
+// selectFiles lists sources of the request and picks files to be compressed
+// applying filters and limits of the sources and of the request
+// in the requested order. Files not changed since the base archive
+// of an incremental one, and files already in the updated archive
+// unless they are changed, are left out before the limits apply
+func selectFiles(req Request, inc *incremental, old *existingArchive) ([]candidate, []Skipped, error) {
+	if err := checkNaming(req); err != nil {
+		return nil, nil, err
+	}
+	filter, err := newFileFilter(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	var candidates []candidate
+	var skipped []Skipped
+	for _, src := range req.sources() {
+		srcCandidates, srcSkipped, err := selectSourceFiles(req, src)
+		if err != nil {
+			return nil, nil, err
+		}
+		candidates = append(candidates, srcCandidates...)
+		skipped = append(skipped, srcSkipped...)
+	}
+	err = sortCandidates(req.Sort, candidates)
+	if err != nil {
+		return nil, nil, err
 	}
-	sort.Slice(fileInfos, func(i, j int) bool {
-		return fileInfos[i].Size() > fileInfos[j].Size()
-	})
 
 	maxFiles := defaultMaxFiles
 	if req.Limit != 0 {
 		maxFiles = req.Limit
 	}
-	count := 0
 
-	for _, file := range fileInfos {
-		filename := file.Name()
-		if req.Filter != "" {
-			match, err := filepath.Match(req.Filter, filename)
+	selected := make([]candidate, 0, maxFiles)
+	var totalBytes int64
+	budgetExceeded := false
+	for _, file := range candidates {
+		if inc != nil {
+			inc.list(file)
+		}
+		if reason := filter.check(file.rel, file.info.Size(), file.info.ModTime()); reason != "" {
+			skipped = append(skipped, Skipped{Path: file.path, Reason: reason})
+			if inc != nil {
+				inc.keep(file)
+			}
+			continue
+		}
+		if inc != nil {
+			changed, err := inc.changed(file)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !changed {
+				skipped = append(skipped, Skipped{Path: file.path, Reason: SkipUnchanged})
+				continue
+			}
+		}
+		if old != nil {
+			reason, err := old.skip(req, file)
 			if err != nil {
-				return http.StatusBadRequest, fmt.Errorf("malformed filter (%w)", err)
+				return nil, nil, err
 			}
-			if !match {
+			if reason != "" {
+				skipped = append(skipped, Skipped{Path: file.path, Reason: reason})
 				continue
 			}
 		}
+		if len(selected) >= maxFiles {
+			skipped = append(skipped, Skipped{Path: file.path, Reason: SkipLimit})
+			continue
+		}
+		// once a file doesn't fit the budget no more files are added
+		if budgetExceeded || (req.MaxTotalBytes > 0 && totalBytes+file.info.Size() > req.MaxTotalBytes) {
+			budgetExceeded = true
+			skipped = append(skipped, Skipped{Path: file.path, Reason: SkipBudget})
+			continue
+		}
+		totalBytes += file.info.Size()
+		selected = append(selected, file)
+		if old != nil {
+			old.replace(file)
+		}
+	}
+
+	if err := checkCollisions(selected); err != nil {
+		return nil, nil, err
+	}
+	return selected, skipped, nil
+}
 
-		fullName := filepath.Join(req.Directory, filename)
-		err := processFile(fullName, writer)
+func sortCandidates(order string, candidates []candidate) error {
+	return sortFiles(order, SortSizeDesc, len(candidates),
+		func(i int) sortKey {
+			info := candidates[i].info
+			return sortKey{name: candidates[i].name, size: info.Size(), mtime: info.ModTime()}
+		},
+		func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+}
+
+// checkCollisions makes sure no two selected files get the same name in the archive
+func checkCollisions(selected []candidate) error {
+	paths := make(map[string]string, len(selected))
+	for _, file := range selected {
+		if other, ok := paths[file.name]; ok {
+			return newError(ErrNameCollision, file.path, file.name, nil,
+				"files %s and %s have the same name %s in archive", other, file.path, file.name)
+		}
+		paths[file.name] = file.path
+	}
+	return nil
+}
+
+// planFiles reports selected files in res without compressing them.
+// Compressed sizes are estimated from a sample of every file
+// and are used to check the archive size limit
+func planFiles(ctx context.Context, res *Result, selected []candidate, c compression, budget *archiveBudget) error {
+	res.DryRun = true
+	for i, file := range selected {
+		if ctx.Err() != nil {
+			return newError(ErrCanceled, "", "", ctx.Err(), "compression canceled")
+		}
+		method, err := c.zipMethod(file)
 		if err != nil {
-			return http.StatusInternalServerError, fmt.Errorf("unable to compress file %s (%w)", filename, err)
+			return err
+		}
+		compressedSize := file.info.Size()
+		if method == zip.Deflate {
+			compressedSize, err = estimateCompressedSize(file.path, file.info.Size(), c.level)
+			if err != nil {
+				return err
+			}
+		}
+		if c.password != "" {
+			compressedSize += aesOverhead
 		}
-		count++
-		if count >= maxFiles {
+		if !budget.fits(file.name, file.path, compressedSize) {
+			res.Skipped = append(res.Skipped, skipAll(selected[i:], SkipBudget)...)
 			break
 		}
+		budget.add(file.name, file.path, compressedSize)
+		res.add(Entry{
+			Name:           file.name,
+			Path:           file.path,
+			Size:           file.info.Size(),
+			CompressedSize: compressedSize,
+			Method:         methodNames[method],
+			Encrypted:      c.password != "",
+		})
 	}
+	return nil
+}
 
-	return http.StatusOK, nil
+// estimateCompressedSize deflates up to sampleSize bytes from the beginning
+// of the file and extrapolates the ratio to its whole size
+func estimateCompressedSize(filename string, size int64, level int) (int64, error) {
+	sample := size
+	if sample > sampleSize {
+		sample = sampleSize
+	}
+	compressedSize, err := deflatedSize(filename, sample, level)
+	if err != nil || sample == 0 {
+		return compressedSize, err
+	}
+	return int64(float64(compressedSize) / float64(sample) * float64(size)), nil
 }
 
-func processFile(filename string, writer *zip.Writer) error {
+// deflatedSize returns the size of up to n first bytes of the file deflated
+func deflatedSize(filename string, n int64, level int) (int64, error) {
 	srcFile, err := os.Open(filename)
 	if err != nil {
-		return fmt.Errorf("unable to open file %s to compress (%w)", filename, err)
+		return 0, newError(ErrInternal, filename, "", err,
+			"unable to open file %s to compress", filename)
 	}
 	defer srcFile.Close()
 
-	destWr, err := writer.Create(filename)
-	if err != nil {
-		return fmt.Errorf("unable to add file %s to archive (%w)", filename, err)
+	var out countingWriter
+	fw := newFlateWriter(&out, level)
+	defer releaseFlateWriter(fw, level)
+	if _, err := io.Copy(fw, io.LimitReader(srcFile, n)); err != nil {
+		return 0, newError(ErrInternal, filename, "", err,
+			"unable to read file %s", filename)
 	}
-	io.Copy(destWr, srcFile)
+	fw.Close()
+	return out.n, nil
+}
 
-	return nil
+func skipAll(files []candidate, reason string) []Skipped {
+	skipped := make([]Skipped, 0, len(files))
+	for _, file := range files {
+		skipped = append(skipped, Skipped{Path: file.path, Reason: reason})
+	}
+	return skipped
+}
+
+// countingWriter discards data counting its size
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
 }
@@ -0,0 +1,164 @@
+package arch
+
+import (
+	"archive/zip"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policies for entries extracted to files that already exist
+const (
+	// ConflictOverwrite replaces the existing file
+	ConflictOverwrite = "overwrite"
+	// ConflictSkip leaves the existing file and skips the entry
+	ConflictSkip = "skip"
+	// ConflictFail stops extraction with ErrConflict
+	ConflictFail = "fail"
+	// ConflictRename extracts the entry next to the existing file
+	// with a numeric suffix, e.g. report.1.txt
+	ConflictRename = "rename"
+	// ConflictNewer replaces the existing file if the entry
+	// is modified later and skips the entry otherwise
+	ConflictNewer = "newer"
+)
+
+// Actions taken on conflicts
+const (
+	ActionOverwritten = "overwritten"
+	ActionSkipped     = "skipped"
+	ActionRenamed     = "renamed"
+)
+
+// Conflict is an entry extracted to a file that already exists
+type Conflict struct {
+	// Name is the name of the entry in the archive
+	Name string `json:"name"`
+	// Path is the existing file
+	Path string `json:"path"`
+	// Policy is one of Conflict* values
+	Policy string `json:"policy"`
+	// Action is one of Action* values
+	Action string `json:"action"`
+	// Renamed is the file the entry is extracted to by ConflictRename
+	Renamed string `json:"renamed,omitempty"`
+}
+
+// conflictResolver applies the policy to targets of entries.
+// Files written by previous archives of a chain are not conflicts,
+// while entries of the same archive extracted to the same file are
+type conflictResolver struct {
+	policy string
+	// archive is the index of the archive in the chain being extracted
+	archive int
+	claimed map[string]claim
+}
+
+// claim is the entry a file is extracted from
+type claim struct {
+	archive int
+	entry   *zip.File
+}
+
+func newConflictResolver(req Request) (*conflictResolver, error) {
+	r := &conflictResolver{policy: req.OnConflict, claimed: map[string]claim{}}
+	switch r.policy {
+	case "":
+		r.policy = ConflictOverwrite
+	case ConflictOverwrite, ConflictSkip, ConflictFail, ConflictRename, ConflictNewer:
+	default:
+		return nil, newError(ErrInvalidOption, "", "", nil, "unknown on_conflict %s", req.OnConflict)
+	}
+	return r, nil
+}
+
+// nextArchive makes files claimed so far belong to previous archives of the chain
+func (r *conflictResolver) nextArchive() {
+	r.archive++
+}
+
+// resolve returns the path entry f with target path is to be extracted to,
+// or an empty path if the entry is skipped. conflict is set if the target
+// exists or another entry of the archive is extracted to it
+func (r *conflictResolver) resolve(f *zip.File, target string) (string, *Conflict, error) {
+	if c, ok := r.claimed[target]; ok {
+		if c.archive != r.archive {
+			r.claimed[target] = claim{archive: r.archive, entry: f}
+			return target, nil, nil
+		}
+		if r.policy == ConflictFail {
+			return "", nil, newError(ErrConflict, target, f.Name, nil,
+				"entries %s and %s are extracted to the same file %s", c.entry.Name, f.Name, target)
+		}
+		return r.apply(f, target, c.entry.Modified)
+	}
+	info, err := os.Lstat(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		r.claimed[target] = claim{archive: r.archive, entry: f}
+		return target, nil, nil
+	}
+	if err != nil {
+		return "", nil, newError(ErrInternal, target, f.Name, err, "unable to check file %s", target)
+	}
+	if r.policy == ConflictFail {
+		return "", nil, newError(ErrConflict, target, f.Name, nil,
+			"file %s already exists", target)
+	}
+	return r.apply(f, target, info.ModTime())
+}
+
+// apply applies the policy to entry f extracted to existing target
+// modified at the given time
+func (r *conflictResolver) apply(f *zip.File, target string, modified time.Time) (string, *Conflict, error) {
+	conflict := &Conflict{Name: f.Name, Path: target, Policy: r.policy}
+	switch r.policy {
+	case ConflictSkip:
+		conflict.Action = ActionSkipped
+	case ConflictRename:
+		renamed, err := r.rename(f, target)
+		if err != nil {
+			return "", nil, err
+		}
+		conflict.Action = ActionRenamed
+		conflict.Renamed = renamed
+		return renamed, conflict, nil
+	case ConflictNewer:
+		// zip keeps modification time in seconds
+		if f.Modified.Unix() > modified.Unix() {
+			conflict.Action = ActionOverwritten
+		} else {
+			conflict.Action = ActionSkipped
+		}
+	default:
+		conflict.Action = ActionOverwritten
+	}
+	if conflict.Action == ActionSkipped {
+		return "", conflict, nil
+	}
+	r.claimed[target] = claim{archive: r.archive, entry: f}
+	return target, conflict, nil
+}
+
+// rename returns the first free path with a numeric suffix for target
+func (r *conflictResolver) rename(f *zip.File, target string) (string, error) {
+	ext := filepath.Ext(target)
+	base := strings.TrimSuffix(target, ext)
+	for i := 1; ; i++ {
+		renamed := base + "." + strconv.Itoa(i) + ext
+		if _, ok := r.claimed[renamed]; ok {
+			continue
+		}
+		_, err := os.Lstat(renamed)
+		if errors.Is(err, fs.ErrNotExist) {
+			r.claimed[renamed] = claim{archive: r.archive, entry: f}
+			return renamed, nil
+		}
+		if err != nil {
+			return "", newError(ErrInternal, renamed, f.Name, err, "unable to check file %s", renamed)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package arch
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// encryptedFlag is the general purpose flag of encrypted entries
+	encryptedFlag = 0x1
+	// strongEncryptionFlag is set for entries encrypted with
+	// PKWARE strong encryption, which is not supported
+	strongEncryptionFlag = 0x40
+)
+
+// KeyResolver returns the password a key reference of Request.KeyRef
+// stands for
+type KeyResolver func(ref string) (string, error)
+
+// password returns the password entries are encrypted or decrypted with,
+// empty if none is given
+func password(req Request) (string, error) {
+	if req.KeyRef == "" {
+		return req.Password, nil
+	}
+	if req.Password != "" {
+		return "", newError(ErrInvalidOption, "", "", nil, "password and key_ref are exclusive")
+	}
+	if req.Keys == nil {
+		return "", newError(ErrInvalidOption, "", "", nil, "no keys to resolve key_ref %s", req.KeyRef)
+	}
+	key, err := req.Keys(req.KeyRef)
+	if err != nil {
+		return "", newError(ErrInvalidOption, "", "", err, "unable to resolve key_ref %s", req.KeyRef)
+	}
+	if key == "" {
+		return "", newError(ErrInvalidOption, "", "", nil, "key_ref %s is empty", req.KeyRef)
+	}
+	return key, nil
+}
+
+// encrypt makes header the one of an entry encrypted with AES-256
+func encrypt(header *zip.FileHeader) {
+	extra := aesExtra{version: aesVersion2, strength: aesStrength256, method: header.Method}
+	header.Method = methodAES
+	header.Flags |= encryptedFlag
+	header.Extra = append(header.Extra, extra.bytes()...)
+	header.CreatorVersion = zipVersion51
+	header.ReaderVersion = zipVersion51
+	// AE-2 entries have no CRC
+	header.CRC32 = 0
+}
+
+// entryMethod returns the compression method of the data of the entry
+func entryMethod(f *zip.File) uint16 {
+	if f.Method == methodAES {
+		if extra, ok := parseAESExtra(f.Extra); ok {
+			return extra.method
+		}
+	}
+	return f.Method
+}
+
+// isEncrypted reports whether the entry is encrypted
+func isEncrypted(f *zip.File) bool {
+	return f.Flags&encryptedFlag != 0
+}
+
+// openEntry opens the entry for reading its uncompressed data, decrypting
+// it with the password if the entry is encrypted with WinZip AES or
+// ZipCrypto. Reading fails with ErrCorruptArchive if the data doesn't
+// match the checksum or the authentication code of the entry
+func openEntry(archiveName string, f *zip.File, password string) (io.ReadCloser, error) {
+	if !isEncrypted(f) {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, newError(ErrCorruptArchive, archiveName, f.Name, err,
+				"unable to open file %s in archive %s", f.Name, archiveName)
+		}
+		return rc, nil
+	}
+	if password == "" {
+		return nil, newError(ErrWrongPassword, archiveName, f.Name, nil,
+			"entry %s is encrypted, password required", f.Name)
+	}
+	if f.Flags&strongEncryptionFlag != 0 {
+		return nil, newError(ErrCorruptArchive, archiveName, f.Name, zip.ErrAlgorithm,
+			"unsupported encryption of entry %s", f.Name)
+	}
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, newError(ErrCorruptArchive, archiveName, f.Name, err,
+			"unable to open file %s in archive %s", f.Name, archiveName)
+	}
+
+	extra, ok := parseAESExtra(f.Extra)
+	if f.Method != methodAES || !ok {
+		data, err := newZipCryptoReader(archiveName, f, raw, password)
+		if err != nil {
+			return nil, err
+		}
+		rc, err := decompress(archiveName, f, f.Method, data)
+		if err != nil {
+			return nil, err
+		}
+		return newChecksumReader(archiveName, f, rc), nil
+	}
+	data, err := newAESReader(archiveName, f, extra, raw, password)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := decompress(archiveName, f, extra.method, data)
+	if err != nil {
+		return nil, err
+	}
+	rc = &authReader{rc: rc, data: data}
+	if extra.version == aesVersion2 {
+		return rc, nil
+	}
+	// AE-1 entries keep CRC in addition to the authentication code
+	return newChecksumReader(archiveName, f, rc), nil
+}
+
+// checksumReader checks CRC-32 of the data of an entry once it is read,
+// as zip.File.Open does for entries that are not encrypted
+type checksumReader struct {
+	rc      io.ReadCloser
+	hash    hash.Hash32
+	archive string
+	f       *zip.File
+}
+
+func newChecksumReader(archiveName string, f *zip.File, rc io.ReadCloser) *checksumReader {
+	return &checksumReader{rc: rc, hash: crc32.NewIEEE(), archive: archiveName, f: f}
+}
+
+func (cr *checksumReader) Read(p []byte) (int, error) {
+	n, err := cr.rc.Read(p)
+	cr.hash.Write(p[:n])
+	if err == io.EOF && cr.hash.Sum32() != cr.f.CRC32 {
+		return n, newError(ErrCorruptArchive, cr.archive, cr.f.Name, zip.ErrChecksum,
+			"checksum of entry %s doesn't match", cr.f.Name)
+	}
+	return n, err
+}
+
+func (cr *checksumReader) Close() error {
+	return cr.rc.Close()
+}
+
+// authReader reads the rest of the encrypted data once the decompressed
+// data ends, so the authentication code is checked. Decompressors stop at
+// the end of the compressed stream without reading to the end of the data
+type authReader struct {
+	rc   io.ReadCloser
+	data io.Reader
+}
+
+func (ar *authReader) Read(p []byte) (int, error) {
+	n, err := ar.rc.Read(p)
+	if err == io.EOF {
+		if _, drainErr := io.Copy(io.Discard, ar.data); drainErr != nil {
+			return n, drainErr
+		}
+	}
+	return n, err
+}
+
+func (ar *authReader) Close() error {
+	return ar.rc.Close()
+}
+
+// decompress returns a reader of the uncompressed data of entry f
+// compressed with method
+func decompress(archiveName string, f *zip.File, method uint16, data io.Reader) (io.ReadCloser, error) {
+	switch method {
+	case zip.Store:
+		return io.NopCloser(data), nil
+	case zip.Deflate:
+		return flate.NewReader(data), nil
+	}
+	return nil, newError(ErrCorruptArchive, archiveName, f.Name, zip.ErrAlgorithm,
+		"unsupported method %d of entry %s", method, f.Name)
+}
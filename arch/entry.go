@@ -0,0 +1,227 @@
+package arch
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	zipVersion20   = 20
+	extTimeExtraID = 0x5455
+	utf8Flag       = 0x800
+
+	// entryOverhead is the upper bound of the size of local and central
+	// directory headers of an entry, not counting its name
+	entryOverhead = 30 + 46 + 2*(9+aesExtraSize+32)
+	// endOverhead is the upper bound of the size of the end of central
+	// directory records
+	endOverhead = 22 + 56 + 20
+)
+
+// compressedEntry is a file deflated ahead of being written to the archive,
+// so its compressed size is known before it is added
+type compressedEntry struct {
+	header *zip.FileHeader
+	data   *spool
+	// method is the compression method of the data, which header
+	// doesn't tell if the entry is encrypted
+	method uint16
+	// crc32 is the checksum of the file, which header
+	// doesn't keep if the entry is encrypted
+	crc32 uint32
+}
+
+// compressEntry compresses the file into a spool
+func compressEntry(file candidate, c compression) (*compressedEntry, error) {
+	method, err := c.zipMethod(file)
+	if err != nil {
+		return nil, err
+	}
+	filename := file.path
+	srcFile, err := os.Open(filename)
+	if err != nil {
+		return nil, newError(ErrInternal, filename, "", err,
+			"unable to open file %s to compress", filename)
+	}
+	defer srcFile.Close()
+
+	data := &spool{}
+	var out io.Writer = data
+	var aw *aesWriter
+	if c.password != "" {
+		aw, err = newAESWriter(data, c.password)
+		if err != nil {
+			data.Close()
+			return nil, newError(ErrInternal, filename, "", err,
+				"unable to encrypt file %s", filename)
+		}
+		out = aw
+	}
+	crc := crc32.NewIEEE()
+	var n int64
+	if method == zip.Store {
+		n, err = io.Copy(io.MultiWriter(out, crc), srcFile)
+	} else {
+		fw := newFlateWriter(out, c.level)
+		defer releaseFlateWriter(fw, c.level)
+		n, err = io.Copy(io.MultiWriter(fw, crc), srcFile)
+		if err == nil {
+			err = fw.Close()
+		}
+	}
+	if err == nil && aw != nil {
+		err = aw.Close()
+	}
+	if err != nil {
+		data.Close()
+		return nil, newError(ErrInternal, filename, "", err,
+			"unable to compress file %s", filename)
+	}
+
+	header := newRawHeader(file.name, file.info.ModTime())
+	header.Method = method
+	header.CRC32 = crc.Sum32()
+	header.UncompressedSize64 = uint64(n)
+	header.CompressedSize64 = uint64(data.Size())
+	if c.password != "" {
+		encrypt(header)
+	}
+
+	return &compressedEntry{header: header, data: data, method: method, crc32: crc.Sum32()}, nil
+}
+
+// flateWriterPools keep flate writers of every level for reuse
+// as they are expensive to create. Pool of level l is at l+1,
+// so the one of flate.DefaultCompression is at 0
+var flateWriterPools [flate.BestCompression + 2]sync.Pool
+
+func newFlateWriter(w io.Writer, level int) *flate.Writer {
+	fw, ok := flateWriterPools[level+1].Get().(*flate.Writer)
+	if ok {
+		fw.Reset(w)
+		return fw
+	}
+	fw, _ = flate.NewWriter(w, level)
+	return fw
+}
+
+func releaseFlateWriter(fw *flate.Writer, level int) {
+	flateWriterPools[level+1].Put(fw)
+}
+
+// write adds the entry to the archive and releases its data
+func (e *compressedEntry) write(writer *zip.Writer) error {
+	defer e.data.Close()
+
+	name := e.header.Name
+	destWr, err := writer.CreateRaw(e.header)
+	if err != nil {
+		return newError(ErrInternal, "", name, err,
+			"unable to add file %s to archive", name)
+	}
+	if _, err := e.data.WriteTo(destWr); err != nil {
+		return newError(ErrInternal, "", name, err,
+			"unable to write file %s to archive", name)
+	}
+	return nil
+}
+
+// newRawHeader creates a header to be written with zip.Writer.CreateRaw.
+// It fills the fields zip.Writer.CreateHeader fills itself
+func newRawHeader(name string, modified time.Time) *zip.FileHeader {
+	header := &zip.FileHeader{
+		Name:           name,
+		CreatorVersion: zipVersion20,
+		ReaderVersion:  zipVersion20,
+		Modified:       modified,
+	}
+	if requiresUTF8(name) {
+		header.Flags |= utf8Flag
+	}
+	if !modified.IsZero() {
+		header.ModifiedDate, header.ModifiedTime = msDosTime(modified)
+
+		// extended timestamp, same as zip.Writer.CreateHeader writes
+		extra := make([]byte, 9)
+		binary.LittleEndian.PutUint16(extra[0:], extTimeExtraID)
+		binary.LittleEndian.PutUint16(extra[2:], 5)
+		extra[4] = 1
+		binary.LittleEndian.PutUint32(extra[5:], uint32(modified.Unix()))
+		header.Extra = append(header.Extra, extra...)
+	}
+	return header
+}
+
+func msDosTime(t time.Time) (fDate uint16, fTime uint16) {
+	fDate = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	fTime = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return
+}
+
+// requiresUTF8 reports whether name is valid UTF-8 that is not ASCII
+func requiresUTF8(name string) bool {
+	if !utf8.ValidString(name) {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveBudget tracks the size of an archive against a limit.
+// Sizes of headers and of the manifest are estimated from above,
+// so an archive fitting the budget is never larger than the limit
+type archiveBudget struct {
+	limit int64
+	used  int64
+	// manifest is set once the manifest is reserved,
+	// then every entry is also counted in the manifest
+	manifest bool
+}
+
+func newArchiveBudget(limit int64) *archiveBudget {
+	return &archiveBudget{limit: limit, used: endOverhead}
+}
+
+// fits reports whether an entry can be added without exceeding the limit.
+// Zero limit means no limit
+func (b *archiveBudget) fits(name, path string, compressedSize int64) bool {
+	return b.limit == 0 || b.used+b.entrySize(name, path, compressedSize) <= b.limit
+}
+
+func (b *archiveBudget) add(name, path string, compressedSize int64) {
+	b.used += b.entrySize(name, path, compressedSize)
+}
+
+// reserveManifest counts the manifest with the files it already lists
+func (b *archiveBudget) reserveManifest(m *Manifest) error {
+	size, err := manifestSize(m)
+	if err != nil {
+		return err
+	}
+	b.used += entrySize(ManifestName, size)
+	b.manifest = true
+	return nil
+}
+
+func (b *archiveBudget) entrySize(name, path string, compressedSize int64) int64 {
+	size := entrySize(name, compressedSize)
+	if b.manifest {
+		size += manifestFileSize(name, path)
+	}
+	return size
+}
+
+func entrySize(name string, compressedSize int64) int64 {
+	return entryOverhead + 2*int64(len(name)) + compressedSize
+}
@@ -0,0 +1,120 @@
+package arch
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+)
+
+// Kinds of errors returned by Compress, Extract, List and Verify.
+// Use errors.Is to check the kind of a returned error
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrMalformedFilter  = errors.New("malformed filter")
+	ErrPermission       = errors.New("permission denied")
+	ErrCorruptArchive   = errors.New("corrupt archive")
+	ErrInvalidPath      = errors.New("invalid path")
+	ErrInvalidOption    = errors.New("invalid option")
+	ErrNameCollision    = errors.New("name collision")
+	ErrConflict         = errors.New("file exists")
+	ErrLimitExceeded    = errors.New("limit exceeded")
+	ErrWrongPassword    = errors.New("wrong password")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrCanceled         = errors.New("canceled")
+	ErrInternal         = errors.New("internal error")
+)
+
+// errorCodes are stable machine-readable codes of error kinds
+var errorCodes = map[error]string{
+	ErrNotFound:         "not_found",
+	ErrMalformedFilter:  "malformed_filter",
+	ErrPermission:       "permission_denied",
+	ErrCorruptArchive:   "corrupt_archive",
+	ErrInvalidPath:      "invalid_path",
+	ErrInvalidOption:    "invalid_option",
+	ErrNameCollision:    "name_collision",
+	ErrConflict:         "conflict",
+	ErrLimitExceeded:    "limit_exceeded",
+	ErrWrongPassword:    "wrong_password",
+	ErrInvalidSignature: "invalid_signature",
+	ErrCanceled:         "canceled",
+	ErrInternal:         "internal",
+}
+
+// Error is returned by the operations of the package when processing fails
+type Error struct {
+	// Kind is one of the Err* values of the package
+	Kind error
+	// Path is the file on disk the error relates to, if any
+	Path string
+	// Entry is the name of the archive entry the error relates to, if any
+	Entry string
+	// Err is the underlying error
+	Err error
+
+	msg string
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.msg
+	}
+	return fmt.Sprintf("%s (%s)", e.msg, e.Err.Error())
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
+
+// Code returns the code of the error kind, e.g. "not_found"
+func (e *Error) Code() string {
+	return errorCodes[e.Kind]
+}
+
+// ErrorCode returns the machine-readable code of err.
+// Errors not produced by the package are reported as "internal"
+func ErrorCode(err error) string {
+	var archErr *Error
+	if errors.As(err, &archErr) {
+		return archErr.Code()
+	}
+	return errorCodes[ErrInternal]
+}
+
+// newError creates an Error with kind derived from err, or fallback if
+// err doesn't tell anything specific
+func newError(fallback error, path, entry string, err error, format string, args ...interface{}) *Error {
+	return &Error{
+		Kind:  classify(err, fallback),
+		Path:  path,
+		Entry: entry,
+		Err:   err,
+		msg:   fmt.Sprintf(format, args...),
+	}
+}
+
+func classify(err, fallback error) error {
+	switch {
+	case err == nil:
+		return fallback
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ErrCanceled
+	case errors.Is(err, fs.ErrNotExist):
+		return ErrNotFound
+	case errors.Is(err, fs.ErrPermission), errors.Is(err, syscall.EROFS):
+		return ErrPermission
+	case errors.Is(err, zip.ErrFormat), errors.Is(err, zip.ErrChecksum),
+		errors.Is(err, zip.ErrAlgorithm):
+		return ErrCorruptArchive
+	case errors.Is(err, syscall.ENOTDIR), errors.Is(err, syscall.EISDIR):
+		return ErrInvalidPath
+	}
+	return fallback
+}
@@ -2,64 +2,386 @@ package arch
 
 import (
 	"archive/zip"
-	"fmt"
+	"context"
+	"errors"
 	"io"
-	"net/http"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
-func Extract(req Request) (int, error) {
-	reader, err := zip.OpenReader(req.ArchiveName)
+// Extract extracts files of archive req.ArchiveName to req.Directory.
+// Archives of req.Chain are extracted before it in order. If req.RequireSignature
+// is set, signatures of all the archives are checked before anything is written.
+// It stops with ErrCanceled when ctx is done. Returned errors are of type *Error
+func Extract(ctx context.Context, req Request) (Result, error) {
+	start := time.Now()
+	var res Result
+
+	archives := append(append([]string(nil), req.Chain...), req.ArchiveName)
+	key, err := signatureKey(req)
 	if err != nil {
-		return http.StatusBadRequest, fmt.Errorf("unable to open archive %s (%w)", req.ArchiveName, err)
+		return res, err
+	}
+	// every archive is opened once and signatures of all of them
+	// are checked before anything is written
+	readers := make([]*openedArchive, 0, len(archives))
+	defer func() {
+		for _, reader := range readers {
+			reader.Close()
+		}
+	}()
+	for _, archive := range archives {
+		reader, err := openArchive(archive, key)
+		if err != nil {
+			return res, err
+		}
+		readers = append(readers, reader)
+	}
+	res.Signed = req.RequireSignature
+	var manifests []*Manifest
+	if len(req.Chain) > 0 {
+		manifests, err = readManifests(archives, readers)
+		if err != nil {
+			return res, err
+		}
+		if err := checkChain(manifests, archives); err != nil {
+			return res, err
+		}
 	}
-	defer reader.Close()
 
-	maxFiles := req.Limit
-	count := 0
-	// for zip no sorting by size is needed
-	// files in reader.File are sorted in order of addition
-	// which is by size in our case
+	var x extraction
+	x.resolver, err = newConflictResolver(req)
+	if err != nil {
+		return res, err
+	}
+	x.limiter, err = newExtractLimiter(req.ExtractLimits)
+	if err != nil {
+		return res, err
+	}
+	x.password, err = password(req)
+	if err != nil {
+		return res, err
+	}
+	if req.Atomic && !req.DryRun {
+		x.tx, err = newTransaction(req.Directory)
+		if err != nil {
+			return res, err
+		}
+		defer x.tx.close()
+	}
 
-	for _, f := range reader.File {
-		filename := filepath.Base(f.Name)
-		if req.Filter != "" {
-			match, err := filepath.Match(req.Filter, filename)
-			if err != nil {
-				return http.StatusBadRequest, fmt.Errorf("malformed filter (%w)", err)
+	for i, archive := range archives {
+		if i > 0 {
+			x.resolver.nextArchive()
+		}
+		archiveReq := req
+		archiveReq.ArchiveName = archive
+		if err := extractArchive(ctx, archiveReq, readers[i].Reader, &res, x); err != nil {
+			return res, err
+		}
+		if i > 0 {
+			if err := removeDeleted(req, manifests[i].Deleted, &res, x.tx); err != nil {
+				return res, err
 			}
-			if !match {
+		}
+	}
+	if x.tx != nil {
+		if err := x.tx.commit(); err != nil {
+			return res, err
+		}
+	}
+	res.Elapsed = time.Since(start)
+	if req.DryRun {
+		return res, nil
+	}
+	bytesExtracted.Add(float64(res.Bytes))
+	filesProcessed.Add(float64(res.Files), opExtract)
+
+	return res, nil
+}
+
+// extraction is the state shared by archives extracted by Extract
+type extraction struct {
+	resolver *conflictResolver
+	limiter  *extractLimiter
+	// password decrypts encrypted entries
+	password string
+	// tx is nil unless the extraction is atomic
+	tx *transaction
+}
+
+// extractArchive extracts files of archive req.ArchiveName read by reader
+// adding them to res. Files are written to the staging directory of x.tx
+// if it is not nil
+func extractArchive(ctx context.Context, req Request, reader *zip.Reader, res *Result, x extraction) error {
+	if err := x.limiter.checkArchive(req.ArchiveName, reader.File); err != nil {
+		return err
+	}
+
+	selected, skipped, err := selectEntries(reader.File, req)
+	if err != nil {
+		return err
+	}
+	res.Skipped = append(res.Skipped, skipped...)
+	for _, t := range selected {
+		if err := x.limiter.checkEntry(req.ArchiveName, t.file); err != nil {
+			return err
+		}
+	}
+	selected, err = resolveConflicts(selected, x.resolver, res)
+	if err != nil {
+		return err
+	}
+	selected = dropOverwritten(selected, res)
+
+	if req.DryRun {
+		res.DryRun = true
+		for _, t := range selected {
+			f := t.file
+			res.add(Entry{
+				Name:           f.Name,
+				Path:           t.path,
+				Size:           int64(f.UncompressedSize64),
+				CompressedSize: int64(f.CompressedSize64),
+				Method:         methodNames[entryMethod(f)],
+				Encrypted:      isEncrypted(f),
+			})
+		}
+		return nil
+	}
+
+	files := make([]*zip.File, len(selected))
+	outs := make([]string, len(selected))
+	for i, t := range selected {
+		files[i], outs[i] = t.file, t.path
+		if x.tx != nil {
+			outs[i] = x.tx.stage(t.path)
+		}
+	}
+	sizes, err := extractFiles(ctx, req.ArchiveName, files, outs, req.Concurrency, x.password, x.limiter)
+	if err != nil {
+		return err
+	}
+	for i, t := range selected {
+		f := t.file
+		res.add(Entry{
+			Name:           f.Name,
+			Path:           t.path,
+			Size:           sizes[i],
+			CompressedSize: int64(f.CompressedSize64),
+			Method:         methodNames[entryMethod(f)],
+			Encrypted:      isEncrypted(f),
+		})
+	}
+	return nil
+}
+
+// extractEntry writes entry f of the archive to file fp within the limits,
+// decrypting it with password if it is encrypted.
+// The file is removed if the entry is not extracted completely
+func extractEntry(archiveName string, f *zip.File, fp, password string, limiter *extractLimiter) (n int64, err error) {
+	if err := os.MkdirAll(filepath.Dir(fp), os.ModePerm); err != nil {
+		return 0, newError(ErrInvalidPath, fp, f.Name, err,
+			"unable to create directory for file %s", fp)
+	}
+	outFile, err := os.OpenFile(fp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+	if err != nil {
+		return 0, newError(ErrInvalidPath, fp, f.Name, err,
+			"unable to create file %s", fp)
+	}
+	defer func() {
+		outFile.Close()
+		if err != nil {
+			os.Remove(fp)
+		}
+	}()
+
+	archFileReader, err := openEntry(archiveName, f, password)
+	if err != nil {
+		return 0, err
+	}
+	defer archFileReader.Close()
+
+	n, err = io.Copy(outFile, limiter.reader(archiveName, f, archFileReader))
+	var archErr *Error
+	if errors.As(err, &archErr) {
+		return n, err
+	}
+	if err != nil {
+		return n, newError(ErrCorruptArchive, archiveName, f.Name, err,
+			"unable to extract file %s from archive %s", f.Name, archiveName)
+	}
+	if err := outFile.Close(); err != nil {
+		return n, newError(ErrInternal, fp, f.Name, err, "unable to write file %s", fp)
+	}
+	return n, nil
+}
+
+// readManifests reads manifests of the archives read by readers
+func readManifests(archives []string, readers []*openedArchive) ([]*Manifest, error) {
+	manifests := make([]*Manifest, 0, len(archives))
+	for i, archive := range archives {
+		m, err := readManifest(readers[i].Reader, archive)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, m)
+	}
+	return manifests, nil
+}
+
+// removeDeleted removes files of entries recorded as deleted
+// by an incremental archive, on commit of tx if it is not nil
+func removeDeleted(req Request, deleted []string, res *Result, tx *transaction) error {
+	mapper, err := newNameMapper(req)
+	if err != nil {
+		return err
+	}
+	for _, name := range deleted {
+		rel, err := mapper.target(name)
+		if err != nil {
+			return err
+		}
+		if rel == "" {
+			continue
+		}
+		fp := filepath.Join(req.Directory, filepath.FromSlash(rel))
+		if tx != nil {
+			if err := tx.remove(fp); err != nil {
+				return err
+			}
+		} else if !req.DryRun {
+			err := os.Remove(fp)
+			if errors.Is(err, fs.ErrNotExist) {
 				continue
 			}
+			if err != nil {
+				return newError(ErrInternal, fp, name, err, "unable to remove deleted file %s", fp)
+			}
 		}
-		fp := filepath.Join(req.Directory, filename)
+		res.Deleted = append(res.Deleted, fp)
+	}
+	return nil
+}
 
-		os.MkdirAll(filepath.Dir(fp), os.ModePerm)
-		outFile, err := os.OpenFile(fp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+// resolveConflicts applies the conflict policy to the targets,
+// reporting conflicts and skipped entries in res
+func resolveConflicts(selected []target, resolver *conflictResolver, res *Result) ([]target, error) {
+	resolved := make([]target, 0, len(selected))
+	for _, t := range selected {
+		fp, conflict, err := resolver.resolve(t.file, t.path)
 		if err != nil {
-			return http.StatusBadRequest,
-				fmt.Errorf("unable to create file %s (%w)", fp, err)
+			return nil, err
+		}
+		if conflict != nil {
+			res.Conflicts = append(res.Conflicts, *conflict)
 		}
-		defer outFile.Close()
+		if fp == "" {
+			res.Skipped = append(res.Skipped, Skipped{Name: t.file.Name, Reason: SkipConflict})
+			continue
+		}
+		resolved = append(resolved, target{file: t.file, path: fp})
+	}
+	return resolved, nil
+}
 
-		archFileReader, err := f.Open()
-		if err != nil {
-			return http.StatusInternalServerError,
-				fmt.Errorf("unable to open file %s in archive %s (%w)", fp, req.ArchiveName, err)
+// dropOverwritten leaves out entries whose files are overwritten by later
+// entries of the archive, so every file is written by one entry and the
+// later one wins whatever the concurrency is
+func dropOverwritten(selected []target, res *Result) []target {
+	last := make(map[string]int, len(selected))
+	for i, t := range selected {
+		last[t.path] = i
+	}
+	if len(last) == len(selected) {
+		return selected
+	}
+	kept := make([]target, 0, len(last))
+	for i, t := range selected {
+		if last[t.path] != i {
+			res.Skipped = append(res.Skipped, Skipped{Name: t.file.Name, Reason: SkipConflict})
+			continue
 		}
-		defer archFileReader.Close()
+		kept = append(kept, t)
+	}
+	return kept
+}
 
-		io.Copy(outFile, archFileReader)
+// target is an entry selected to be extracted
+type target struct {
+	file *zip.File
+	// path is the file the entry is extracted to
+	path string
+}
+
+// selectEntries picks entries of the archive to be extracted
+// applying filters and limit of the request in the requested order
+func selectEntries(files []*zip.File, req Request) ([]target, []Skipped, error) {
+	filter, err := newFileFilter(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	mapper, err := newNameMapper(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	maxFiles := req.Limit
+	ordered, err := sortEntries(files, req)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		if maxFiles == 0 {
+	var skipped []Skipped
+	selected := make([]target, 0, len(ordered))
+	for _, f := range ordered {
+		if f.Name == ManifestName {
+			skipped = append(skipped, Skipped{Name: f.Name, Reason: SkipManifest})
+			continue
+		}
+		if strings.HasSuffix(f.Name, "/") {
+			skipped = append(skipped, Skipped{Name: f.Name, Reason: SkipDirectory})
+			continue
+		}
+		if reason := filter.check(f.Name, int64(f.UncompressedSize64), f.Modified); reason != "" {
+			skipped = append(skipped, Skipped{Name: f.Name, Reason: reason})
+			continue
+		}
+		name, err := mapper.target(f.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if name == "" {
+			skipped = append(skipped, Skipped{Name: f.Name, Reason: SkipName})
 			continue
 		}
-		count++
-		if count >= maxFiles {
-			break
+		if maxFiles != 0 && len(selected) >= maxFiles {
+			skipped = append(skipped, Skipped{Name: f.Name, Reason: SkipLimit})
+			continue
 		}
+		selected = append(selected, target{
+			file: f,
+			path: filepath.Join(req.Directory, filepath.FromSlash(name)),
+		})
 	}
 
-	return http.StatusOK, nil
+	return selected, skipped, nil
+}
+
+// sortEntries returns entries of the archive in the requested order
+func sortEntries(files []*zip.File, req Request) ([]*zip.File, error) {
+	// by default no sorting is needed
+	// files in reader.File are sorted in order of addition
+	// which is by size for archives created by Compress
+	ordered := make([]*zip.File, len(files))
+	copy(ordered, files)
+	err := sortFiles(req.Sort, SortArchive, len(ordered),
+		func(i int) sortKey {
+			f := ordered[i]
+			return sortKey{name: f.Name, size: int64(f.UncompressedSize64), mtime: f.Modified}
+		},
+		func(i, j int) { ordered[i], ordered[j] = ordered[j], ordered[i] })
+	return ordered, err
 }
@@ -0,0 +1,177 @@
+package arch
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// pattern matches slash separated relative paths.
+// It is either a glob split by segments or a regular expression
+type pattern struct {
+	glob []string
+	re   *regexp.Regexp
+}
+
+// fileFilter decides which files are processed. A file is processed if its
+// base name matches filter, its relative path matches any of include patterns
+// and none of exclude patterns, and its size and modification time are in
+// the requested ranges. Empty filter or include match everything
+type fileFilter struct {
+	filter     string
+	include    []pattern
+	exclude    []pattern
+	ignoreCase bool
+
+	minSize        int64
+	maxSize        int64
+	modifiedAfter  *time.Time
+	modifiedBefore *time.Time
+}
+
+// newFileFilter compiles patterns of the request
+// so malformed ones are reported before any file is processed
+func newFileFilter(req Request) (*fileFilter, error) {
+	f := &fileFilter{
+		filter:         req.Filter,
+		ignoreCase:     req.IgnoreCase,
+		minSize:        req.MinSize,
+		maxSize:        req.MaxSize,
+		modifiedAfter:  req.ModifiedAfter,
+		modifiedBefore: req.ModifiedBefore,
+	}
+	if f.minSize < 0 || f.maxSize < 0 || (f.maxSize > 0 && f.minSize > f.maxSize) {
+		return nil, newError(ErrInvalidOption, "", "", nil,
+			"invalid size range [%d, %d]", f.minSize, f.maxSize)
+	}
+	if f.modifiedAfter != nil && f.modifiedBefore != nil && !f.modifiedAfter.Before(*f.modifiedBefore) {
+		return nil, newError(ErrInvalidOption, "", "", nil,
+			"modified_after %s is not before modified_before %s",
+			f.modifiedAfter.Format(time.RFC3339), f.modifiedBefore.Format(time.RFC3339))
+	}
+	if f.ignoreCase {
+		f.filter = strings.ToLower(f.filter)
+	}
+	if _, err := path.Match(f.filter, ""); err != nil {
+		return nil, newError(ErrMalformedFilter, "", "", err, "malformed filter %s", req.Filter)
+	}
+
+	var err error
+	f.include, err = compilePatterns(req.Include, req.Regex, req.IgnoreCase)
+	if err != nil {
+		return nil, err
+	}
+	f.exclude, err = compilePatterns(req.Exclude, req.Regex, req.IgnoreCase)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func compilePatterns(patterns []string, isRegex, ignoreCase bool) ([]pattern, error) {
+	compiled := make([]pattern, 0, len(patterns))
+	for _, p := range patterns {
+		c, err := compilePattern(p, isRegex, ignoreCase)
+		if err != nil {
+			return nil, newError(ErrMalformedFilter, "", "", err, "malformed pattern %s", p)
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+func compilePattern(p string, isRegex, ignoreCase bool) (pattern, error) {
+	if isRegex {
+		if ignoreCase {
+			p = "(?i)" + p
+		}
+		re, err := regexp.Compile(p)
+		return pattern{re: re}, err
+	}
+
+	if ignoreCase {
+		p = strings.ToLower(p)
+	}
+	glob := strings.Split(strings.Trim(p, "/"), "/")
+	for _, segment := range glob {
+		if _, err := path.Match(segment, ""); err != nil {
+			return pattern{}, err
+		}
+	}
+	return pattern{glob: glob}, nil
+}
+
+// match reports whether a relative slash separated path matches the pattern.
+// ignoreCase patterns expect rel to be lower case
+func (p pattern) match(rel string) bool {
+	if p.re != nil {
+		return p.re.MatchString(rel)
+	}
+	return matchSegments(p.glob, strings.Split(rel, "/"))
+}
+
+// matchSegments matches path segments against glob segments,
+// where "**" stands for any number of segments
+func matchSegments(glob, segments []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(glob[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(glob[0], segments[0]); !ok {
+			return false
+		}
+		glob, segments = glob[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+// check returns the reason for a file to be skipped,
+// or an empty string if it is to be processed
+func (f *fileFilter) check(rel string, size int64, mtime time.Time) string {
+	if !f.match(rel) {
+		return SkipFilter
+	}
+	if size < f.minSize || (f.maxSize > 0 && size > f.maxSize) {
+		return SkipSize
+	}
+	if (f.modifiedAfter != nil && !mtime.After(*f.modifiedAfter)) ||
+		(f.modifiedBefore != nil && !mtime.Before(*f.modifiedBefore)) {
+		return SkipModified
+	}
+	return ""
+}
+
+// match reports whether a file with relative slash separated path rel
+// matches the patterns
+func (f *fileFilter) match(rel string) bool {
+	if f.ignoreCase {
+		rel = strings.ToLower(rel)
+	}
+	if f.filter != "" {
+		if ok, _ := path.Match(f.filter, path.Base(rel)); !ok {
+			return false
+		}
+	}
+	if len(f.include) > 0 && !matchAny(f.include, rel) {
+		return false
+	}
+	return !matchAny(f.exclude, rel)
+}
+
+func matchAny(patterns []pattern, rel string) bool {
+	for _, p := range patterns {
+		if p.match(rel) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package arch
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFileName is the name of files listing patterns of files
+// to be left out on compression, in gitignore syntax
+const IgnoreFileName = ".archiveignore"
+
+// ignoreRule is a line of an ignore file
+type ignoreRule struct {
+	glob    []string
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreList holds rules of an ignore file
+type ignoreList struct {
+	// base is the slash separated path of the directory of the ignore file
+	// relative to the source directory, empty for the source directory
+	base  string
+	rules []ignoreRule
+}
+
+// ignoreMatcher decides which files are ignored by ignore files found
+// in the source directory and its subdirectories. Like in git, rules of
+// deeper files take precedence, as do later rules of the same file
+type ignoreMatcher struct {
+	lists []ignoreList
+}
+
+// load reads the ignore file of directory dir with relative path base, if any
+func (m *ignoreMatcher) load(dir, base string) error {
+	filename := filepath.Join(dir, IgnoreFileName)
+	file, err := os.Open(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return newError(ErrInternal, filename, "", err, "unable to read ignore file %s", filename)
+	}
+	defer file.Close()
+
+	list := ignoreList{base: base}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		rule, ok, err := parseIgnoreRule(scanner.Text())
+		if err != nil {
+			return newError(ErrMalformedFilter, filename, "", err,
+				"malformed pattern %s in ignore file %s", scanner.Text(), filename)
+		}
+		if ok {
+			list.rules = append(list.rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return newError(ErrInternal, filename, "", err, "unable to read ignore file %s", filename)
+	}
+	if len(list.rules) > 0 {
+		m.lists = append(m.lists, list)
+	}
+	return nil
+}
+
+// parseIgnoreRule parses a line of an ignore file.
+// ok is false for blank lines and comments
+func parseIgnoreRule(line string) (rule ignoreRule, ok bool, err error) {
+	line = strings.TrimRight(line, " \t\r")
+	if strings.HasSuffix(line, "\\") {
+		// the trailing space was escaped
+		line += " "
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false, nil
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\#") || strings.HasPrefix(line, "\\!") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule, false, nil
+	}
+	// patterns without a slash but at the end match at any depth,
+	// the others are relative to the directory of the ignore file
+	anchored := strings.Contains(line, "/")
+	rule.glob = strings.Split(strings.TrimPrefix(line, "/"), "/")
+	if !anchored {
+		rule.glob = append([]string{"**"}, rule.glob...)
+	}
+	for _, segment := range rule.glob {
+		if _, err := path.Match(segment, ""); err != nil {
+			return rule, false, err
+		}
+	}
+	return rule, true, nil
+}
+
+// ignored reports whether a file or a directory with slash separated path
+// rel relative to the source directory is ignored
+func (m *ignoreMatcher) ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, list := range m.lists {
+		sub := rel
+		if list.base != "" {
+			if !strings.HasPrefix(rel, list.base+"/") {
+				continue
+			}
+			sub = rel[len(list.base)+1:]
+		}
+		segments := strings.Split(sub, "/")
+		for _, rule := range list.rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if matchSegments(rule.glob, segments) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
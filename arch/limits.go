@@ -0,0 +1,130 @@
+package arch
+
+import (
+	"archive/zip"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+// ratioThreshold is the uncompressed size of an entry in bytes
+// from which ExtractLimits.MaxRatio is checked, so small entries
+// of repeated data are not taken for bombs
+const ratioThreshold = 1 << 20
+
+// ExtractLimits protect Extract from archives exhausting resources,
+// e.g. zip bombs. Sizes are checked against the headers of entries and
+// while entries are decompressed, as headers may lie. Zero means no limit
+type ExtractLimits struct {
+	// MaxBytes limits the total uncompressed size of the files extracted
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+	// MaxEntryBytes limits the uncompressed size of every file extracted
+	MaxEntryBytes int64 `json:"max_entry_bytes,omitempty"`
+	// MaxRatio limits the ratio of uncompressed to compressed size
+	// of entries larger than 1MB
+	MaxRatio float64 `json:"max_ratio,omitempty"`
+	// MaxEntries limits the number of entries in the archive
+	MaxEntries int `json:"max_entries,omitempty"`
+	// MaxDepth limits the number of directories in entry names
+	MaxDepth int `json:"max_depth,omitempty"`
+}
+
+// Cap returns the limits lowered to max where max is stricter,
+// e.g. for the limits of a request not to exceed the configured ones
+func (l ExtractLimits) Cap(max ExtractLimits) ExtractLimits {
+	if max.MaxBytes > 0 && (l.MaxBytes == 0 || l.MaxBytes > max.MaxBytes) {
+		l.MaxBytes = max.MaxBytes
+	}
+	if max.MaxEntryBytes > 0 && (l.MaxEntryBytes == 0 || l.MaxEntryBytes > max.MaxEntryBytes) {
+		l.MaxEntryBytes = max.MaxEntryBytes
+	}
+	if max.MaxRatio > 0 && (l.MaxRatio == 0 || l.MaxRatio > max.MaxRatio) {
+		l.MaxRatio = max.MaxRatio
+	}
+	if max.MaxEntries > 0 && (l.MaxEntries == 0 || l.MaxEntries > max.MaxEntries) {
+		l.MaxEntries = max.MaxEntries
+	}
+	if max.MaxDepth > 0 && (l.MaxDepth == 0 || l.MaxDepth > max.MaxDepth) {
+		l.MaxDepth = max.MaxDepth
+	}
+	return l
+}
+
+// extractLimiter enforces the limits over all archives of an extraction.
+// Entries may be read by several goroutines at once
+type extractLimiter struct {
+	limits ExtractLimits
+	// total is the number of bytes extracted so far, accessed atomically
+	total int64
+}
+
+func newExtractLimiter(limits ExtractLimits) (*extractLimiter, error) {
+	if limits.MaxBytes < 0 || limits.MaxEntryBytes < 0 || limits.MaxRatio < 0 ||
+		limits.MaxEntries < 0 || limits.MaxDepth < 0 {
+		return nil, newError(ErrInvalidOption, "", "", nil, "negative extract limits %+v", limits)
+	}
+	return &extractLimiter{limits: limits}, nil
+}
+
+// checkArchive checks the number of entries of the archive
+func (l *extractLimiter) checkArchive(archiveName string, files []*zip.File) error {
+	if l.limits.MaxEntries > 0 && len(files) > l.limits.MaxEntries {
+		return newError(ErrLimitExceeded, archiveName, "", nil,
+			"archive %s has %d entries, more than %d", archiveName, len(files), l.limits.MaxEntries)
+	}
+	return nil
+}
+
+// checkEntry checks the name and the sizes declared by the header of the entry
+func (l *extractLimiter) checkEntry(archiveName string, f *zip.File) error {
+	if depth := strings.Count(strings.Trim(f.Name, "/"), "/"); l.limits.MaxDepth > 0 && depth > l.limits.MaxDepth {
+		return newError(ErrLimitExceeded, archiveName, f.Name, nil,
+			"entry %s is nested %d directories deep, more than %d", f.Name, depth, l.limits.MaxDepth)
+	}
+	n := int64(f.UncompressedSize64)
+	return l.checkSize(archiveName, f, n, atomic.LoadInt64(&l.total)+n)
+}
+
+// checkSize checks n bytes of entry f making total bytes extracted
+func (l *extractLimiter) checkSize(archiveName string, f *zip.File, n, total int64) error {
+	if l.limits.MaxEntryBytes > 0 && n > l.limits.MaxEntryBytes {
+		return newError(ErrLimitExceeded, archiveName, f.Name, nil,
+			"entry %s is larger than %d bytes", f.Name, l.limits.MaxEntryBytes)
+	}
+	if l.limits.MaxBytes > 0 && total > l.limits.MaxBytes {
+		return newError(ErrLimitExceeded, archiveName, f.Name, nil,
+			"extracted files are larger than %d bytes", l.limits.MaxBytes)
+	}
+	if l.limits.MaxRatio > 0 && n > ratioThreshold &&
+		float64(n) > l.limits.MaxRatio*float64(f.CompressedSize64) {
+		return newError(ErrLimitExceeded, archiveName, f.Name, nil,
+			"entry %s has compression ratio higher than %g", f.Name, l.limits.MaxRatio)
+	}
+	return nil
+}
+
+// reader returns a reader of the entry failing with ErrLimitExceeded
+// once the entry exceeds the limits. The bytes read are added to the total
+func (l *extractLimiter) reader(archiveName string, f *zip.File, r io.Reader) io.Reader {
+	return &limitedReader{r: r, limiter: l, archiveName: archiveName, f: f}
+}
+
+type limitedReader struct {
+	r           io.Reader
+	limiter     *extractLimiter
+	archiveName string
+	f           *zip.File
+	n           int64
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		lr.n += int64(n)
+		total := atomic.AddInt64(&lr.limiter.total, int64(n))
+		if limitErr := lr.limiter.checkSize(lr.archiveName, lr.f, lr.n, total); limitErr != nil {
+			return 0, limitErr
+		}
+	}
+	return n, err
+}
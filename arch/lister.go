@@ -0,0 +1,160 @@
+package arch
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// List lists entries of archive req.ArchiveName applying filters, sort
+// and limit of the request. If req.Password is given, it is checked
+// against encrypted entries without decrypting their data.
+// Returned errors are of type *Error
+func List(ctx context.Context, req Request) (Result, error) {
+	return inspect(ctx, req, false)
+}
+
+// Verify reads every entry of archive req.ArchiveName selected as by List
+// and checks its data against its checksum, or its authentication code if
+// the entry is encrypted with WinZip AES. Encrypted entries are decrypted
+// with req.Password. Data is decompressed within req.ExtractLimits and is
+// not written anywhere. If req.RequireSignature is set, the signature of the
+// archive is checked first. Returned errors are of type *Error
+func Verify(ctx context.Context, req Request) (Result, error) {
+	return inspect(ctx, req, true)
+}
+
+// inspect lists the entries of the archive reading their data if verify is set
+func inspect(ctx context.Context, req Request, verify bool) (Result, error) {
+	start := time.Now()
+	var res Result
+
+	password, err := password(req)
+	if err != nil {
+		return res, err
+	}
+	var key ed25519.PublicKey
+	if verify {
+		key, err = signatureKey(req)
+		if err != nil {
+			return res, err
+		}
+	}
+	limiter, err := newExtractLimiter(req.ExtractLimits)
+	if err != nil {
+		return res, err
+	}
+	reader, err := openArchive(req.ArchiveName, key)
+	if err != nil {
+		return res, err
+	}
+	defer reader.Close()
+	res.Signed = key != nil
+	if verify {
+		if err := limiter.checkArchive(req.ArchiveName, reader.File); err != nil {
+			return res, err
+		}
+	}
+
+	selected, skipped, err := listEntries(reader.File, req)
+	if err != nil {
+		return res, err
+	}
+	res.Skipped = skipped
+	for _, f := range selected {
+		if ctx.Err() != nil {
+			return res, newError(ErrCanceled, req.ArchiveName, "", ctx.Err(), "listing canceled")
+		}
+		switch {
+		case verify:
+			err = verifyEntry(req.ArchiveName, f, password, limiter)
+		case password != "" && isEncrypted(f):
+			err = checkPassword(req.ArchiveName, f, password)
+		}
+		if err != nil {
+			return res, err
+		}
+		modified := f.Modified
+		res.add(Entry{
+			Name:           f.Name,
+			Size:           int64(f.UncompressedSize64),
+			CompressedSize: int64(f.CompressedSize64),
+			Method:         methodNames[entryMethod(f)],
+			Encrypted:      isEncrypted(f),
+			Modified:       &modified,
+		})
+	}
+	res.Elapsed = time.Since(start)
+	return res, nil
+}
+
+// listEntries picks entries of the archive applying filters
+// and limit of the request in the requested order
+func listEntries(files []*zip.File, req Request) ([]*zip.File, []Skipped, error) {
+	filter, err := newFileFilter(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	ordered, err := sortEntries(files, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var skipped []Skipped
+	selected := make([]*zip.File, 0, len(ordered))
+	for _, f := range ordered {
+		if f.Name == ManifestName {
+			skipped = append(skipped, Skipped{Name: f.Name, Reason: SkipManifest})
+			continue
+		}
+		if strings.HasSuffix(f.Name, "/") {
+			skipped = append(skipped, Skipped{Name: f.Name, Reason: SkipDirectory})
+			continue
+		}
+		if reason := filter.check(f.Name, int64(f.UncompressedSize64), f.Modified); reason != "" {
+			skipped = append(skipped, Skipped{Name: f.Name, Reason: reason})
+			continue
+		}
+		if req.Limit != 0 && len(selected) >= req.Limit {
+			skipped = append(skipped, Skipped{Name: f.Name, Reason: SkipLimit})
+			continue
+		}
+		selected = append(selected, f)
+	}
+	return selected, skipped, nil
+}
+
+// checkPassword checks the password against the encryption header of the entry
+func checkPassword(archiveName string, f *zip.File, password string) error {
+	rc, err := openEntry(archiveName, f, password)
+	if err != nil {
+		return err
+	}
+	return rc.Close()
+}
+
+// verifyEntry reads the data of the entry checking it
+func verifyEntry(archiveName string, f *zip.File, password string, limiter *extractLimiter) error {
+	if err := limiter.checkEntry(archiveName, f); err != nil {
+		return err
+	}
+	rc, err := openEntry(archiveName, f, password)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(io.Discard, limiter.reader(archiveName, f, rc))
+	var archErr *Error
+	if errors.As(err, &archErr) {
+		return err
+	}
+	if err != nil {
+		return newError(ErrCorruptArchive, archiveName, f.Name, err,
+			"unable to read file %s from archive %s", f.Name, archiveName)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package arch
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ManifestName is the name of the entry holding the manifest of an archive
+const ManifestName = ".archivarius/manifest.json"
+
+// Manifest describes the files backed up by an archive. The manifest of
+// an incremental archive lists the files of the whole chain of archives
+// it is based on, so it can be the base of the next incremental archive
+type Manifest struct {
+	// ID identifies the archive
+	ID string `json:"id"`
+	// BaseID is the ID of the archive an incremental archive is based on
+	BaseID  string         `json:"base_id,omitempty"`
+	Created time.Time      `json:"created"`
+	Files   []ManifestFile `json:"files"`
+	// Deleted are names of entries of the base archive whose files are gone
+	Deleted []string `json:"deleted,omitempty"`
+}
+
+// ManifestFile is a file backed up by the archive or the archives it is based on
+type ManifestFile struct {
+	// Name is the name of the entry in the archive
+	Name     string    `json:"name"`
+	Path     string    `json:"path,omitempty"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	CRC32    uint32    `json:"crc32"`
+}
+
+func newManifest() *Manifest {
+	return &Manifest{ID: uuid.New().String(), Created: time.Now().UTC()}
+}
+
+// readManifest reads the manifest of the archive, nil if it has none
+func readManifest(reader *zip.Reader, archiveName string) (*Manifest, error) {
+	for _, f := range reader.File {
+		if f.Name != ManifestName {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, newError(ErrCorruptArchive, archiveName, f.Name, err,
+				"unable to open manifest of archive %s", archiveName)
+		}
+		defer rc.Close()
+		var m Manifest
+		if err := json.NewDecoder(rc).Decode(&m); err != nil {
+			return nil, newError(ErrCorruptArchive, archiveName, f.Name, err,
+				"malformed manifest of archive %s", archiveName)
+		}
+		return &m, nil
+	}
+	return nil, nil
+}
+
+// write adds the manifest to the archive
+func (m *Manifest) write(writer *zip.Writer) error {
+	header := newRawHeader(ManifestName, m.Created)
+	header.Method = zip.Deflate
+	w, err := writer.CreateHeader(header)
+	if err == nil {
+		err = json.NewEncoder(w).Encode(m)
+	}
+	if err != nil {
+		return newError(ErrInternal, "", ManifestName, err, "unable to write manifest")
+	}
+	return nil
+}
+
+// manifestSize returns the upper bound of the size of the manifest
+// written to the archive
+func manifestSize(m *Manifest) (int64, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return 0, newError(ErrInternal, "", ManifestName, err, "unable to write manifest")
+	}
+	// deflate adds at most 5 bytes per block of 16K
+	n := int64(len(data)) + 1
+	return n + 5*(n/16384+2), nil
+}
+
+// manifestFileSize returns the upper bound of the size a file adds
+// to the manifest. Escaped characters of names take up to 6 bytes,
+// numbers and times take their longest form
+func manifestFileSize(name, path string) int64 {
+	const fields = len(`{"name":"","path":"","size":,"modified":"","crc32":},`)
+	const values = len("-9223372036854775808") + len(time.RFC3339Nano) + len("4294967295")
+	// the last byte is for deflate blocks the file adds to
+	return int64(fields+values+6*(len(name)+len(path))) + 1
+}
+
+// incremental selects files changed since the base archive
+type incremental struct {
+	base    *Manifest
+	files   map[string]ManifestFile
+	compare string
+	// seen are names of files listed before filters apply,
+	// unchanged are the ones left out
+	seen      map[string]bool
+	unchanged []ManifestFile
+}
+
+// newIncremental reads the manifest of base archive req.Incremental.
+// It returns nil if the archive is not incremental
+func newIncremental(req Request) (*incremental, error) {
+	if req.Incremental == "" {
+		return nil, nil
+	}
+	if req.Mode != "" && req.Mode != ModeCreate {
+		return nil, newError(ErrInvalidOption, "", "", nil,
+			"incremental archive can't be created in %s mode", req.Mode)
+	}
+	if err := checkCompare(req); err != nil {
+		return nil, err
+	}
+	reader, err := zip.OpenReader(req.Incremental)
+	if err != nil {
+		return nil, newError(ErrCorruptArchive, req.Incremental, "", err,
+			"unable to open base archive %s", req.Incremental)
+	}
+	defer reader.Close()
+	base, err := readManifest(&reader.Reader, req.Incremental)
+	if err != nil {
+		return nil, err
+	}
+	if base == nil {
+		return nil, newError(ErrInvalidOption, req.Incremental, "", nil,
+			"base archive %s has no manifest", req.Incremental)
+	}
+
+	inc := &incremental{
+		base:    base,
+		files:   make(map[string]ManifestFile, len(base.Files)),
+		compare: req.Compare,
+		seen:    map[string]bool{},
+	}
+	for _, f := range base.Files {
+		inc.files[f.Name] = f
+	}
+	return inc, nil
+}
+
+// list records the file as listed, so it is not reported as deleted
+func (inc *incremental) list(file candidate) {
+	inc.seen[file.name] = true
+}
+
+// keep carries the file of the base archive to the manifest
+// if the file is left out by filters
+func (inc *incremental) keep(file candidate) {
+	if f, ok := inc.files[file.name]; ok {
+		f.Path = file.path
+		inc.unchanged = append(inc.unchanged, f)
+	}
+}
+
+// changed reports whether the file is new or changed since the base archive
+func (inc *incremental) changed(file candidate) (bool, error) {
+	f, ok := inc.files[file.name]
+	if !ok {
+		return true, nil
+	}
+	changed, err := isChanged(file, f.Size, f.Modified, f.CRC32, inc.compare)
+	if err != nil || changed {
+		return changed, err
+	}
+	f.Path = file.path
+	inc.unchanged = append(inc.unchanged, f)
+	return false, nil
+}
+
+// manifest returns the manifest of the incremental archive
+// with the files of the base archive that are not changed
+func (inc *incremental) manifest() *Manifest {
+	m := newManifest()
+	m.BaseID = inc.base.ID
+	m.Files = append(m.Files, inc.unchanged...)
+	for _, f := range inc.base.Files {
+		if !inc.seen[f.Name] {
+			m.Deleted = append(m.Deleted, f.Name)
+		}
+	}
+	return m
+}
+
+// checkChain makes sure every incremental archive of the chain is based
+// on the previous one
+func checkChain(manifests []*Manifest, archives []string) error {
+	for i := 1; i < len(manifests); i++ {
+		if manifests[i] == nil || manifests[i-1] == nil {
+			return newError(ErrInvalidOption, archives[i], "", nil,
+				"archives of a chain must have manifests")
+		}
+		if manifests[i].BaseID != manifests[i-1].ID {
+			return newError(ErrInvalidOption, archives[i], "", nil,
+				"archive %s is not based on %s", archives[i], archives[i-1])
+		}
+	}
+	return nil
+}
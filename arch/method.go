@@ -0,0 +1,99 @@
+package arch
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"path/filepath"
+	"strings"
+)
+
+// Compression methods
+const (
+	MethodDeflate = "deflate"
+	MethodStore   = "store"
+	// MethodAuto stores files that are already compressed
+	// and deflates the others
+	MethodAuto = "auto"
+)
+
+const (
+	// probeSize is the number of bytes deflated to decide
+	// whether a file is worth compressing in MethodAuto
+	probeSize = 16 * 1024
+	// storeRatio is the compression ratio of the probe
+	// above which a file is stored
+	storeRatio = 0.95
+)
+
+// methodNames are names of zip methods reported in Entry.Method
+var methodNames = map[uint16]string{
+	zip.Store:   MethodStore,
+	zip.Deflate: MethodDeflate,
+}
+
+// compressedExts are extensions of files that are already compressed
+var compressedExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true,
+	".mp3": true, ".ogg": true, ".flac": true, ".aac": true, ".m4a": true,
+	".mp4": true, ".mkv": true, ".mov": true, ".avi": true, ".webm": true,
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true,
+	".7z": true, ".rar": true, ".jar": true, ".docx": true, ".xlsx": true, ".pdf": true,
+}
+
+// compression is the method and the level files are compressed with,
+// and the password they are encrypted with if any
+type compression struct {
+	method   string
+	level    int
+	password string
+}
+
+// newCompression validates compression options of the request
+func newCompression(req Request) (compression, error) {
+	c := compression{method: req.Method, level: flate.DefaultCompression}
+	switch c.method {
+	case "":
+		c.method = MethodDeflate
+	case MethodDeflate, MethodStore, MethodAuto:
+	default:
+		return c, newError(ErrInvalidOption, "", "", nil, "unknown method %s", req.Method)
+	}
+	if req.Level != nil {
+		if *req.Level < flate.NoCompression || *req.Level > flate.BestCompression {
+			return c, newError(ErrInvalidOption, "", "", nil,
+				"level %d is out of range [%d, %d]", *req.Level, flate.NoCompression, flate.BestCompression)
+		}
+		c.level = *req.Level
+	}
+	var err error
+	c.password, err = password(req)
+	return c, err
+}
+
+// zipMethod returns the zip method the file is compressed with
+func (c compression) zipMethod(file candidate) (uint16, error) {
+	switch c.method {
+	case MethodStore:
+		return zip.Store, nil
+	case MethodDeflate:
+		return zip.Deflate, nil
+	}
+	if compressedExts[strings.ToLower(filepath.Ext(file.path))] {
+		return zip.Store, nil
+	}
+	size := file.info.Size()
+	if size > probeSize {
+		size = probeSize
+	}
+	if size == 0 {
+		return zip.Deflate, nil
+	}
+	compressedSize, err := deflatedSize(file.path, size, c.level)
+	if err != nil {
+		return 0, err
+	}
+	if float64(compressedSize) > float64(size)*storeRatio {
+		return zip.Store, nil
+	}
+	return zip.Deflate, nil
+}
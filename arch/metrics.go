@@ -0,0 +1,17 @@
+package arch
+
+import "github.com/12z/archivarius/metrics"
+
+const (
+	opCompress = "compress"
+	opExtract  = "extract"
+)
+
+var (
+	bytesCompressed = metrics.NewCounterVec("archivarius_compressed_bytes_total",
+		"Uncompressed bytes read from source files and written to archives")
+	bytesExtracted = metrics.NewCounterVec("archivarius_extracted_bytes_total",
+		"Bytes extracted from archives and written to files")
+	filesProcessed = metrics.NewCounterVec("archivarius_files_processed_total",
+		"Files compressed into or extracted from archives", "operation")
+)
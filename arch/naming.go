@@ -0,0 +1,126 @@
+package arch
+
+import (
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Namings of entries on compression
+const (
+	// NamingFull names entries by the path of the file on disk
+	NamingFull = "full"
+	// NamingRelative names entries by the path of the file
+	// relative to its source directory
+	NamingRelative = "relative"
+)
+
+// Rename is a rule renaming entries on extraction, like tar --transform.
+// All matches of Pattern regular expression are replaced with Replace,
+// which may refer to submatches as $1
+type Rename struct {
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+}
+
+// checkNaming validates naming options of compression
+func checkNaming(req Request) error {
+	if req.Naming != "" && req.Naming != NamingFull && req.Naming != NamingRelative {
+		return newError(ErrInvalidOption, "", "", nil, "unknown naming %s", req.Naming)
+	}
+	prefixes := []string{req.Prefix}
+	for _, src := range req.Sources {
+		prefixes = append(prefixes, src.Prefix)
+	}
+	for _, prefix := range prefixes {
+		if prefix != "" && !isLocalName(path.Clean(prefix)) {
+			return newError(ErrInvalidOption, "", "", nil, "prefix %s leaves the archive root", prefix)
+		}
+	}
+	return nil
+}
+
+// sourceEntryName returns the name in the archive of file p of the source
+// with slash separated path rel relative to the source
+func sourceEntryName(src Source, naming, p, rel string) string {
+	if src.Prefix != "" {
+		return path.Join(src.Prefix, rel)
+	}
+	if naming == NamingRelative {
+		return rel
+	}
+	return p
+}
+
+// nameMapper maps names of entries to paths files are extracted to
+type nameMapper struct {
+	strip     int
+	rules     []renameRule
+	keepPaths bool
+}
+
+type renameRule struct {
+	re      *regexp.Regexp
+	replace string
+}
+
+func newNameMapper(req Request) (*nameMapper, error) {
+	if req.StripComponents < 0 {
+		return nil, newError(ErrInvalidOption, "", "", nil,
+			"negative strip_components %d", req.StripComponents)
+	}
+	m := &nameMapper{
+		strip:     req.StripComponents,
+		keepPaths: req.KeepPaths || req.StripComponents > 0 || len(req.Rename) > 0,
+	}
+	for _, rule := range req.Rename {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, newError(ErrInvalidOption, "", "", err,
+				"malformed rename pattern %s", rule.Pattern)
+		}
+		m.rules = append(m.rules, renameRule{re: re, replace: rule.Replace})
+	}
+	return m, nil
+}
+
+// target returns the slash separated path relative to the destination
+// directory the entry is extracted to. Empty path means the entry
+// is left out as nothing remains of its name.
+// Names leaving the destination directory are reported with ErrInvalidPath
+func (m *nameMapper) target(name string) (string, error) {
+	mapped := name
+	if m.strip > 0 {
+		segments := strings.Split(strings.TrimLeft(name, "/"), "/")
+		if len(segments) <= m.strip {
+			return "", nil
+		}
+		mapped = strings.Join(segments[m.strip:], "/")
+	}
+	for _, rule := range m.rules {
+		mapped = rule.re.ReplaceAllString(mapped, rule.replace)
+	}
+	if mapped == "" {
+		return "", nil
+	}
+	if m.keepPaths {
+		mapped = path.Clean(mapped)
+	} else {
+		mapped = path.Base(mapped)
+	}
+	if !isLocalName(mapped) {
+		return "", newError(ErrInvalidPath, "", name, nil,
+			"entry %s would be extracted outside of the directory as %s", name, mapped)
+	}
+	return mapped, nil
+}
+
+// isLocalName reports whether a cleaned slash separated name stays
+// within the directory it is relative to
+func isLocalName(name string) bool {
+	if name == "." || name == ".." || path.IsAbs(name) || strings.HasPrefix(name, "../") {
+		return false
+	}
+	return !filepath.IsAbs(filepath.FromSlash(name)) && filepath.VolumeName(filepath.FromSlash(name)) == ""
+}
@@ -0,0 +1,156 @@
+package arch
+
+import (
+	"archive/zip"
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// compressResult is a file compressed by a worker of compressFiles
+type compressResult struct {
+	entry *compressedEntry
+	err   error
+}
+
+// compressFiles compresses files with up to workers files at once and passes
+// the entries to add in order of files, so the archive doesn't depend on
+// the number of workers. add returning false stops compression.
+// Entries not passed to add are released
+func compressFiles(ctx context.Context, files []candidate, c compression, workers int,
+	add func(i int, entry *compressedEntry) (bool, error)) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers == 1 {
+		return compressSerially(ctx, files, c, add)
+	}
+	results := make([]chan compressResult, len(files))
+	for i := range results {
+		results[i] = make(chan compressResult, 1)
+	}
+	// slots bounds the number of entries compressed or waiting to be added,
+	// a slot is released once the entry is added
+	slots := make(chan struct{}, workers)
+	done := make(chan struct{})
+	produced := make(chan struct{})
+	// started is the number of files passed to workers, read once produced is closed
+	started := 0
+
+	go func() {
+		defer close(produced)
+		for i := range files {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			started++
+			go func(i int) {
+				entry, err := compressEntry(files[i], c)
+				results[i] <- compressResult{entry: entry, err: err}
+			}(i)
+		}
+	}()
+	defer func() {
+		close(done)
+		<-produced
+		// wait for the started workers and release their entries
+		for i := 0; i < started; i++ {
+			if r := <-results[i]; r.entry != nil {
+				r.entry.data.Close()
+			}
+		}
+	}()
+
+	for i := range files {
+		if ctx.Err() != nil {
+			return newError(ErrCanceled, "", "", ctx.Err(), "compression canceled")
+		}
+		var r compressResult
+		select {
+		case r = <-results[i]:
+		case <-ctx.Done():
+			return newError(ErrCanceled, "", "", ctx.Err(), "compression canceled")
+		}
+		// the result is consumed, so it is not waited for on return
+		results[i] <- compressResult{}
+		<-slots
+		if r.err != nil {
+			return r.err
+		}
+		ok, err := add(i, r.entry)
+		if err != nil || !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// compressSerially compresses files one by one in the calling goroutine,
+// which archives made by compressFiles are identical to
+func compressSerially(ctx context.Context, files []candidate, c compression,
+	add func(i int, entry *compressedEntry) (bool, error)) error {
+	for i, file := range files {
+		if ctx.Err() != nil {
+			return newError(ErrCanceled, "", "", ctx.Err(), "compression canceled")
+		}
+		entry, err := compressEntry(file, c)
+		if err != nil {
+			return err
+		}
+		ok, err := add(i, entry)
+		if err != nil || !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractFiles extracts entries of the archive to files outs with up to
+// workers entries at once, so only the files of the entries being extracted
+// are open whatever the size of the archive. It returns the sizes of the files.
+// Entries not started yet are not extracted once any of them fails
+func extractFiles(ctx context.Context, archiveName string, files []*zip.File, outs []string,
+	workers int, password string, limiter *extractLimiter) ([]int64, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	sizes := make([]int64, len(files))
+	errs := make([]error, len(files))
+	slots := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	var failed int32
+
+	var err error
+	for i := range files {
+		if ctx.Err() != nil {
+			err = newError(ErrCanceled, archiveName, "", ctx.Err(), "extraction canceled")
+			break
+		}
+		slots <- struct{}{}
+		if atomic.LoadInt32(&failed) != 0 {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			sizes[i], errs[i] = extractEntry(archiveName, files[i], outs[i], password, limiter)
+			if errs[i] != nil {
+				atomic.StoreInt32(&failed, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, e := range errs {
+		if e != nil {
+			return sizes, e
+		}
+	}
+	return sizes, err
+}
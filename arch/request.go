@@ -0,0 +1,228 @@
+// Package arch compresses files into zip archives and extracts them back.
+//
+// Compress, Extract, List and Verify take a context and a Request holding
+// the options of the operation, and return a Result with statistics of
+// what was processed.
+// Errors are of type *Error, use errors.Is with the Err* values to check
+// their kind:
+//
+//	res, err := arch.Compress(ctx, arch.Request{
+//		ArchiveName: "backup.zip",
+//		Directory:   "logs",
+//		Filter:      "*.log",
+//	})
+//	if errors.Is(err, arch.ErrNotFound) {
+//		...
+//	}
+package arch
+
+import (
+	"crypto/ed25519"
+	"time"
+)
+
+// Request holds the options of Compress, Extract, List and Verify
+type Request struct {
+	ArchiveName string `json:"file"`
+	Directory   string `json:"dir"`
+	// Sources are files and directories compressed in addition to Directory
+	Sources []Source `json:"sources,omitempty"`
+	// Naming is how entries are named on compression, one of Naming* values.
+	// NamingFull is used by default
+	Naming string `json:"naming,omitempty"`
+	// Prefix is prepended to names of entries compressed from Directory,
+	// which are named by their path relative to Directory then
+	Prefix string `json:"prefix,omitempty"`
+	// StripComponents removes leading directories from entry names
+	// on extraction, entries with nothing left are skipped
+	StripComponents int `json:"strip_components,omitempty"`
+	// Rename rules are applied in order to entry names on extraction
+	// after StripComponents
+	Rename []Rename `json:"rename,omitempty"`
+	// KeepPaths extracts entries to their path relative to Directory.
+	// By default entries are extracted to their base name in Directory
+	// unless StripComponents or Rename is set
+	KeepPaths bool   `json:"keep_paths,omitempty"`
+	Filter    string `json:"filter,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
+	// Include and Exclude are patterns matched against slash separated
+	// paths relative to Directory for compression, or entry names for
+	// extraction. "**" matches any number of directories
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// Regex makes Include and Exclude regular expressions instead of globs
+	Regex bool `json:"regex,omitempty"`
+	// IgnoreCase makes Filter, Include and Exclude case-insensitive
+	IgnoreCase bool `json:"ignore_case,omitempty"`
+	// Recursive makes Compress descend into subdirectories of Directory
+	Recursive bool `json:"recursive,omitempty"`
+	// NoIgnore makes Compress disregard IgnoreFileName files
+	// found in source directories
+	NoIgnore bool `json:"no_ignore,omitempty"`
+	// MinSize and MaxSize select files by uncompressed size in bytes,
+	// both inclusive. MaxSize of 0 means no upper bound
+	MinSize int64 `json:"min_size,omitempty"`
+	MaxSize int64 `json:"max_size,omitempty"`
+	// ModifiedAfter and ModifiedBefore select files by modification time
+	ModifiedAfter  *time.Time `json:"modified_after,omitempty"`
+	ModifiedBefore *time.Time `json:"modified_before,omitempty"`
+	// Sort is the order files are selected in, one of Sort* values.
+	// SortSizeDesc is used by default for compression and SortArchive
+	// for extraction
+	Sort string `json:"sort,omitempty"`
+	// MaxTotalBytes limits the total uncompressed size of files to be
+	// compressed. Once a file doesn't fit, no more files are added
+	MaxTotalBytes int64 `json:"max_total_bytes,omitempty"`
+	// MaxArchiveBytes limits the size of the archive created. Once a file
+	// doesn't fit, no more files are added
+	MaxArchiveBytes int64 `json:"max_archive_bytes,omitempty"`
+	// Method is the compression method, one of Method* values.
+	// MethodDeflate is used by default
+	Method string `json:"method,omitempty"`
+	// Level is the deflate compression level from 0 (no compression)
+	// to 9 (best compression). The default level of flate is used if nil
+	Level *int `json:"level,omitempty"`
+	// Mode is how Compress treats an existing archive, one of Mode* values.
+	// ModeCreate is used by default
+	Mode string `json:"mode,omitempty"`
+	// Compare is how changed files are detected in ModeUpdate,
+	// one of Compare* values. CompareMtime is used by default
+	Compare string `json:"compare,omitempty"`
+	// Manifest adds a manifest listing the files compressed to the archive
+	// as entry ManifestName
+	Manifest bool `json:"manifest,omitempty"`
+	// Incremental is the path of the archive an incremental archive is
+	// based on. Only files new or changed since it, as told by Compare,
+	// are compressed, and the files gone are recorded as deleted in
+	// the manifest of the incremental archive
+	Incremental string `json:"incremental,omitempty"`
+	// Chain are archives extracted before ArchiveName in order, the full
+	// archive followed by incremental ones. Files recorded as deleted in
+	// an incremental archive are removed from Directory
+	Chain []string `json:"chain,omitempty"`
+	// OnConflict is what Extract does with files that already exist,
+	// one of Conflict* values. ConflictOverwrite is used by default
+	OnConflict string `json:"on_conflict,omitempty"`
+	// Atomic makes Extract write files to a staging directory and move them
+	// into place only once all of them are extracted. Files replaced are
+	// restored if moving fails, so Directory is either left untouched
+	// or gets all the files
+	Atomic bool `json:"atomic,omitempty"`
+	// ExtractLimits abort Extract with ErrLimitExceeded
+	// on archives exhausting resources
+	ExtractLimits ExtractLimits `json:"extract_limits,omitempty"`
+	// Password makes Compress encrypt files with AES-256 (WinZip AE-2)
+	// and Extract, List and Verify decrypt them. Entries encrypted with
+	// traditional PKWARE encryption (ZipCrypto) are decrypted too, but
+	// never created. Files that are not encrypted are extracted as they
+	// are. The manifest is not encrypted
+	Password string `json:"password,omitempty"`
+	// KeyRef names a key used as Password, which is resolved by Keys
+	KeyRef string `json:"key_ref,omitempty"`
+	// Keys resolves KeyRef, e.g. to keys configured for the service
+	Keys KeyResolver `json:"-"`
+	// Sign makes Compress sign the archive with SigningKey, as one of
+	// the Sign* values. Signatures are ed25519 over SHA-512 of the archive
+	Sign string `json:"sign,omitempty"`
+	// RequireSignature makes Extract and Verify fail with ErrInvalidSignature
+	// unless archives are signed with the private key of VerifyKey
+	RequireSignature bool `json:"require_signature,omitempty"`
+	// SigningKey signs archives, e.g. the key configured for the service
+	SigningKey ed25519.PrivateKey `json:"-"`
+	// VerifyKey checks signatures, the public key of SigningKey by default
+	VerifyKey ed25519.PublicKey `json:"-"`
+	// Concurrency is the number of files compressed or extracted at once,
+	// runtime.NumCPU() by default. Entries are written and reported in
+	// the same order whatever the concurrency is
+	Concurrency int `json:"concurrency,omitempty"`
+	// DryRun only selects files to be processed and reports them in Result
+	// without creating the archive or writing any files
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// Reasons for files to be skipped
+const (
+	SkipFilter    = "filter"
+	SkipSize      = "size"
+	SkipModified  = "modified"
+	SkipLimit     = "limit"
+	SkipBudget    = "budget"
+	SkipDirectory = "directory"
+	SkipName      = "name"
+	SkipIgnored   = "ignored"
+	SkipExists    = "exists"
+	SkipUnchanged = "unchanged"
+	SkipManifest  = "manifest"
+	SkipConflict  = "conflict"
+)
+
+// Entry is a file compressed into or extracted from an archive
+type Entry struct {
+	// Name is the name of the entry in the archive
+	Name string `json:"name"`
+	// Path is the file on disk, not set by List and Verify
+	Path string `json:"path,omitempty"`
+	// Size is the uncompressed size of the file
+	Size int64 `json:"size"`
+	// CompressedSize is the size of the file in the archive
+	CompressedSize int64 `json:"compressed_size"`
+	// Method is the compression method of the entry, one of Method* values
+	Method string `json:"method,omitempty"`
+	// Encrypted is set for entries encrypted with a password
+	Encrypted bool `json:"encrypted,omitempty"`
+	// Modified is the modification time of the entry, set by List and Verify
+	Modified *time.Time `json:"modified,omitempty"`
+}
+
+// Skipped is a file or an archive entry that was not processed
+type Skipped struct {
+	// Name is the name of the entry in the archive, set for extraction
+	Name string `json:"name,omitempty"`
+	// Path is the file on disk, set for compression
+	Path string `json:"path,omitempty"`
+	// Reason is one of the Skip* values
+	Reason string `json:"reason"`
+}
+
+// Result describes a finished operation
+type Result struct {
+	// Entries are the files processed, in order of processing
+	Entries []Entry `json:"entries,omitempty"`
+	// Skipped are the files left out
+	Skipped []Skipped `json:"skipped,omitempty"`
+	// Files is the number of files compressed or extracted
+	Files int `json:"files"`
+	// Bytes is the total uncompressed size of the files
+	Bytes int64 `json:"bytes"`
+	// CompressedBytes is the total compressed size of the files
+	CompressedBytes int64 `json:"compressed_bytes"`
+	// Ratio is CompressedBytes to Bytes, 0 if nothing was processed
+	Ratio float64 `json:"ratio"`
+	// Elapsed is the duration of the operation in nanoseconds
+	Elapsed time.Duration `json:"elapsed_ns"`
+	// Conflicts are entries extracted to files that already existed
+	Conflicts []Conflict `json:"conflicts,omitempty"`
+	// Deleted are names of entries recorded as deleted by an incremental
+	// archive on compression, or files removed from Directory on extraction
+	Deleted []string `json:"deleted,omitempty"`
+	// Kept is the number of entries of an updated archive
+	// kept as they were
+	Kept int `json:"kept,omitempty"`
+	// DryRun is true if nothing was written. Entries are the planned ones
+	// then, and compressed sizes of files to be compressed are estimated
+	DryRun bool `json:"dry_run,omitempty"`
+	// Signed is true if the archive was signed by Compress,
+	// or its signature was checked by Extract or Verify
+	Signed bool `json:"signed,omitempty"`
+}
+
+// add accounts a processed file in the result
+func (r *Result) add(entry Entry) {
+	r.Entries = append(r.Entries, entry)
+	r.Files++
+	r.Bytes += entry.Size
+	r.CompressedBytes += entry.CompressedSize
+	if r.Bytes > 0 {
+		r.Ratio = float64(r.CompressedBytes) / float64(r.Bytes)
+	}
+}
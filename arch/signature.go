@@ -0,0 +1,206 @@
+package arch
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// Ways to sign archives
+const (
+	// SignDetached writes the signature next to the archive
+	// to a file named as the archive with SignatureExt
+	SignDetached = "detached"
+	// SignComment embeds the signature into the comment of the archive
+	SignComment = "comment"
+)
+
+// SignatureExt is the extension of detached signatures
+const SignatureExt = ".sig"
+
+// signaturePrefix starts comments holding signatures
+const signaturePrefix = "archivarius-ed25519:"
+
+// signatureCommentSize is the size of comments holding signatures,
+// which is fixed, so the archive is known before the signature is
+var signatureCommentSize = len(signaturePrefix) + base64.StdEncoding.EncodedLen(ed25519.SignatureSize)
+
+// Archives are signed with ed25519 over SHA-512 of their content, so they
+// don't have to be kept in memory. The content is the whole file for detached
+// signatures and the file up to the comment for embedded ones. A detached
+// signature is the raw signature, an embedded one is signaturePrefix followed
+// by the signature in base64
+
+// checkSign validates signing options of the request
+func checkSign(req Request) error {
+	switch req.Sign {
+	case "":
+		return nil
+	case SignDetached, SignComment:
+	default:
+		return newError(ErrInvalidOption, "", "", nil, "unknown sign %s", req.Sign)
+	}
+	if len(req.SigningKey) != ed25519.PrivateKeySize {
+		return newError(ErrInvalidOption, "", "", nil, "no key to sign archive with")
+	}
+	return nil
+}
+
+// signaturePlaceholder returns the comment the signature replaces
+func signaturePlaceholder() string {
+	return signaturePrefix + strings.Repeat("=", signatureCommentSize-len(signaturePrefix))
+}
+
+// digest returns SHA-512 of the first size bytes of the file
+func digest(file io.ReaderAt, size int64) ([]byte, error) {
+	h := sha512.New()
+	if _, err := io.Copy(h, io.NewSectionReader(file, 0, size)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// signArchive signs the complete archive written with a placeholder comment
+// if sign is SignComment. It returns the detached signature file to be
+// committed after the archive if sign is SignDetached
+func signArchive(archive *atomicFile, sign string, key ed25519.PrivateKey) (*atomicFile, error) {
+	info, err := archive.Stat()
+	if err != nil {
+		return nil, newError(ErrInternal, archive.target, "", err, "unable to sign archive %s", archive.target)
+	}
+	size := info.Size()
+	if sign == SignComment {
+		size -= int64(signatureCommentSize)
+	}
+	sum, err := digest(archive, size)
+	if err != nil {
+		return nil, newError(ErrInternal, archive.target, "", err, "unable to sign archive %s", archive.target)
+	}
+	signature := ed25519.Sign(key, sum)
+
+	if sign == SignComment {
+		comment := signaturePrefix + base64.StdEncoding.EncodeToString(signature)
+		if _, err := archive.WriteAt([]byte(comment), size); err != nil {
+			return nil, newError(ErrInternal, archive.target, "", err, "unable to sign archive %s", archive.target)
+		}
+		return nil, nil
+	}
+	sigFile, err := createAtomic(archive.target + SignatureExt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := sigFile.Write(signature); err != nil {
+		sigFile.discard()
+		return nil, newError(ErrInternal, sigFile.target, "", err, "unable to write signature %s", sigFile.target)
+	}
+	return sigFile, nil
+}
+
+// verifyKey returns the key signatures are checked with
+func verifyKey(req Request) (ed25519.PublicKey, error) {
+	if len(req.VerifyKey) == ed25519.PublicKeySize {
+		return req.VerifyKey, nil
+	}
+	if len(req.SigningKey) == ed25519.PrivateKeySize {
+		return req.SigningKey.Public().(ed25519.PublicKey), nil
+	}
+	return nil, newError(ErrInvalidOption, "", "", nil, "no key to verify signature with")
+}
+
+// signatureKey returns the key signatures of archives are checked with,
+// nil if the request doesn't require signatures
+func signatureKey(req Request) (ed25519.PublicKey, error) {
+	if !req.RequireSignature {
+		return nil, nil
+	}
+	return verifyKey(req)
+}
+
+// openedArchive is an archive read through the file its signature is
+// checked on, so the archive can't be replaced after the check
+type openedArchive struct {
+	*zip.Reader
+	file *os.File
+}
+
+func (a *openedArchive) Close() error {
+	return a.file.Close()
+}
+
+// openArchive opens the archive checking its signature with key
+// unless key is nil
+func openArchive(archiveName string, key ed25519.PublicKey) (*openedArchive, error) {
+	file, err := os.Open(archiveName)
+	if err != nil {
+		return nil, newError(ErrCorruptArchive, archiveName, "", err, "unable to open archive %s", archiveName)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, newError(ErrCorruptArchive, archiveName, "", err, "unable to open archive %s", archiveName)
+	}
+	if key != nil {
+		if err := verifySignature(archiveName, file, info.Size(), key); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	reader, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, newError(ErrCorruptArchive, archiveName, "", err, "unable to open archive %s", archiveName)
+	}
+	return &openedArchive{Reader: reader, file: file}, nil
+}
+
+// verifySignature checks the detached signature of the archive of the
+// given size, or the embedded one if there is no detached signature
+func verifySignature(archiveName string, file io.ReaderAt, size int64, key ed25519.PublicKey) error {
+	signature, err := os.ReadFile(archiveName + SignatureExt)
+	if errors.Is(err, fs.ErrNotExist) {
+		signature, err = readSignatureComment(file, size)
+		size -= int64(signatureCommentSize)
+	}
+	if err != nil {
+		return newError(ErrInvalidSignature, archiveName, "", err,
+			"unable to read signature of archive %s", archiveName)
+	}
+	if signature == nil {
+		return newError(ErrInvalidSignature, archiveName, "", nil, "archive %s is not signed", archiveName)
+	}
+
+	sum, err := digest(file, size)
+	if err != nil {
+		return newError(ErrCorruptArchive, archiveName, "", err, "unable to read archive %s", archiveName)
+	}
+	if len(signature) != ed25519.SignatureSize || !ed25519.Verify(key, sum, signature) {
+		return newError(ErrInvalidSignature, archiveName, "", nil,
+			"signature of archive %s doesn't match", archiveName)
+	}
+	return nil
+}
+
+// readSignatureComment returns the signature embedded into
+// the comment of the archive, nil if the comment holds none
+func readSignatureComment(file io.ReaderAt, size int64) ([]byte, error) {
+	// the comment follows its length, which ends the end of central directory record
+	tail := make([]byte, 2+signatureCommentSize)
+	if size < int64(len(tail)) {
+		return nil, nil
+	}
+	if _, err := file.ReadAt(tail, size-int64(len(tail))); err != nil {
+		return nil, err
+	}
+	comment := string(tail[2:])
+	if int(binary.LittleEndian.Uint16(tail)) != signatureCommentSize || !strings.HasPrefix(comment, signaturePrefix) {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimPrefix(comment, signaturePrefix))
+}
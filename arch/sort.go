@@ -0,0 +1,64 @@
+package arch
+
+import (
+	"sort"
+	"time"
+)
+
+// Orders of files selection
+const (
+	SortSizeDesc  = "size_desc"
+	SortSizeAsc   = "size_asc"
+	SortName      = "name"
+	SortMtimeAsc  = "mtime_asc"
+	SortMtimeDesc = "mtime_desc"
+	// SortArchive keeps the order of entries in the archive on extraction
+	// and the order of directory listing, which is by name, on compression
+	SortArchive = "archive"
+)
+
+// sortKey holds the properties files are ordered by
+type sortKey struct {
+	name  string
+	size  int64
+	mtime time.Time
+}
+
+type lessFunc func(a, b sortKey) bool
+
+var sortOrders = map[string]lessFunc{
+	SortSizeDesc:  func(a, b sortKey) bool { return a.size > b.size },
+	SortSizeAsc:   func(a, b sortKey) bool { return a.size < b.size },
+	SortName:      func(a, b sortKey) bool { return a.name < b.name },
+	SortMtimeAsc:  func(a, b sortKey) bool { return a.mtime.Before(b.mtime) },
+	SortMtimeDesc: func(a, b sortKey) bool { return a.mtime.After(b.mtime) },
+	SortArchive:   nil,
+}
+
+// sortFiles orders n files by order, fallback is used if order is empty.
+// key returns properties of i-th file, swap swaps two files
+func sortFiles(order, fallback string, n int, key func(i int) sortKey, swap func(i, j int)) error {
+	if order == "" {
+		order = fallback
+	}
+	less, ok := sortOrders[order]
+	if !ok {
+		return newError(ErrInvalidOption, "", "", nil, "unknown sort order %s", order)
+	}
+	if less == nil {
+		return nil
+	}
+	sort.Stable(sorter{n: n, key: key, swap: swap, less: less})
+	return nil
+}
+
+type sorter struct {
+	n    int
+	key  func(i int) sortKey
+	swap func(i, j int)
+	less lessFunc
+}
+
+func (s sorter) Len() int           { return s.n }
+func (s sorter) Less(i, j int) bool { return s.less(s.key(i), s.key(j)) }
+func (s sorter) Swap(i, j int)      { s.swap(i, j) }
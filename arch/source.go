@@ -0,0 +1,161 @@
+package arch
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Source is a file or a directory to be compressed
+type Source struct {
+	// Path is the file or the directory on disk
+	Path string `json:"path"`
+	// Prefix is prepended to names of entries of the source in the archive.
+	// Entries are named by their path relative to Path then.
+	// Without Prefix entries are named according to Request.Naming
+	Prefix string `json:"prefix,omitempty"`
+	// Filter, Include, Exclude and Limit select files of this source only.
+	// Files selected from all sources are then filtered and limited
+	// by the options of the request
+	Filter  string   `json:"filter,omitempty"`
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	Limit   int      `json:"limit,omitempty"`
+
+	// dirOnly makes Path fail to be listed if it is not a directory
+	dirOnly bool
+}
+
+// sources returns sources of the request, Directory is the first one if set
+func (req Request) sources() []Source {
+	sources := make([]Source, 0, len(req.Sources)+1)
+	if req.Directory != "" || len(req.Sources) == 0 {
+		sources = append(sources, Source{Path: req.Directory, Prefix: req.Prefix, dirOnly: true})
+	}
+	return append(sources, req.Sources...)
+}
+
+// selectSourceFiles lists the source and applies its own filters and limit
+func selectSourceFiles(req Request, src Source) ([]candidate, []Skipped, error) {
+	filter, err := newFileFilter(Request{
+		Filter:     src.Filter,
+		Include:    src.Include,
+		Exclude:    src.Exclude,
+		Regex:      req.Regex,
+		IgnoreCase: req.IgnoreCase,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	candidates, skipped, err := listSource(req, src)
+	if err != nil {
+		return nil, nil, err
+	}
+	if src.Filter == "" && len(src.Include) == 0 && len(src.Exclude) == 0 && src.Limit == 0 {
+		return candidates, skipped, nil
+	}
+	err = sortCandidates(req.Sort, candidates)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	selected := make([]candidate, 0, len(candidates))
+	for _, file := range candidates {
+		if !filter.match(file.rel) {
+			skipped = append(skipped, Skipped{Path: file.path, Reason: SkipFilter})
+			continue
+		}
+		if src.Limit != 0 && len(selected) >= src.Limit {
+			skipped = append(skipped, Skipped{Path: file.path, Reason: SkipLimit})
+			continue
+		}
+		selected = append(selected, file)
+	}
+	return selected, skipped, nil
+}
+
+// listSource returns files of the source, descending into subdirectories
+// if req.Recursive is set. Otherwise subdirectories are skipped.
+// Files matching ignore files of the source are skipped unless req.NoIgnore is set
+func listSource(req Request, src Source) ([]candidate, []Skipped, error) {
+	var skipped []Skipped
+	var candidates []candidate
+	var ignore ignoreMatcher
+
+	// WalkDir doesn't follow a symlinked root, so its target is walked
+	// and files are reported under src.Path as if the link was walked
+	root := src.Path
+	if info, err := os.Lstat(root); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			root = resolved
+		}
+	}
+
+	// room for optimisation
+	err := filepath.WalkDir(root, func(walked string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		p := src.Path
+		if walked == root && entry.IsDir() {
+			if req.NoIgnore {
+				return nil
+			}
+			return ignore.load(p, "")
+		}
+		if walked == root && src.dirOnly {
+			return &fs.PathError{Op: "readdir", Path: p, Err: syscall.ENOTDIR}
+		}
+		rel := filepath.Base(src.Path)
+		if walked != root {
+			rel, err = filepath.Rel(root, walked)
+			if err != nil {
+				return newError(ErrInternal, walked, "", err,
+					"unable to get relative path of file %s", walked)
+			}
+			p = filepath.Join(src.Path, rel)
+		}
+		rel = filepath.ToSlash(rel)
+		if entry.IsDir() {
+			if !req.Recursive {
+				skipped = append(skipped, Skipped{Path: p, Reason: SkipDirectory})
+				return filepath.SkipDir
+			}
+			if ignore.ignored(rel, true) {
+				skipped = append(skipped, Skipped{Path: p, Reason: SkipIgnored})
+				return filepath.SkipDir
+			}
+			if req.NoIgnore {
+				return nil
+			}
+			return ignore.load(p, rel)
+		}
+		if ignore.ignored(rel, false) {
+			skipped = append(skipped, Skipped{Path: p, Reason: SkipIgnored})
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return newError(ErrInternal, p, "", err,
+				"error reading info for file %s", entry.Name())
+		}
+		candidates = append(candidates, candidate{
+			path: p,
+			rel:  rel,
+			name: sourceEntryName(src, req.Naming, p, rel),
+			info: info,
+		})
+		return nil
+	})
+	if err != nil {
+		var archErr *Error
+		if errors.As(err, &archErr) {
+			return nil, nil, err
+		}
+		return nil, nil, newError(ErrInvalidPath, src.Path, "", err,
+			"unable to list %s", src.Path)
+	}
+	return candidates, skipped, nil
+}
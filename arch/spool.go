@@ -0,0 +1,69 @@
+package arch
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// spoolMemory is the amount of data a spool keeps in memory
+const spoolMemory = 4 * 1024 * 1024
+
+// spool is a buffer keeping up to spoolMemory bytes in memory
+// and moving to a temporary file when it grows larger
+type spool struct {
+	buf  bytes.Buffer
+	file *os.File
+	size int64
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	if s.file == nil && s.buf.Len()+len(p) > spoolMemory {
+		f, err := os.CreateTemp("", "archivarius-*")
+		if err != nil {
+			return 0, err
+		}
+		s.file = f
+		if _, err := s.buf.WriteTo(f); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	var err error
+	if s.file != nil {
+		n, err = s.file.Write(p)
+	} else {
+		n, err = s.buf.Write(p)
+	}
+	s.size += int64(n)
+	return n, err
+}
+
+// Size returns the number of bytes written to the spool
+func (s *spool) Size() int64 {
+	return s.size
+}
+
+// WriteTo writes all the data of the spool to w
+func (s *spool) WriteTo(w io.Writer) (int64, error) {
+	if s.file == nil {
+		return io.Copy(w, bytes.NewReader(s.buf.Bytes()))
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, s.file)
+}
+
+// Close releases memory and removes the temporary file if any
+func (s *spool) Close() error {
+	s.buf = bytes.Buffer{}
+	if s.file == nil {
+		return nil
+	}
+	name := s.file.Name()
+	s.file.Close()
+	s.file = nil
+	return os.Remove(name)
+}
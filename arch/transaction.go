@@ -0,0 +1,189 @@
+package arch
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// stagingPattern is the pattern of names of directories
+// created in the destination directory by a transaction
+const stagingPattern = ".archivarius-*"
+
+// transaction extracts files into a staging directory and moves them
+// into place on commit. Files replaced or removed by the transaction are
+// moved to a backup directory, so a failed commit can restore them
+type transaction struct {
+	dir     string
+	staging string
+	backup  string
+	// createdDir is set if dir didn't exist before the transaction
+	createdDir bool
+
+	// staged are target paths of staged files in order of staging
+	staged   []string
+	isStaged map[string]bool
+	// deleted are target paths to be removed on commit
+	deleted []string
+
+	// applied, backups and createdDirs track a commit to be rolled back
+	applied     []string
+	backups     []string
+	createdDirs []string
+	committed   bool
+}
+
+func newTransaction(dir string) (*transaction, error) {
+	tx := &transaction{dir: filepath.Clean(dir), isStaged: map[string]bool{}}
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		tx.createdDir = true
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, newError(ErrInvalidPath, dir, "", err, "unable to create directory %s", dir)
+	}
+	var err error
+	tx.staging, err = os.MkdirTemp(dir, stagingPattern)
+	if err == nil {
+		tx.backup, err = os.MkdirTemp(dir, stagingPattern)
+	}
+	if err != nil {
+		tx.close()
+		return nil, newError(ErrInvalidPath, dir, "", err,
+			"unable to create staging directory in %s", dir)
+	}
+	return tx, nil
+}
+
+// mirror returns the path in root corresponding to target path in dir
+func (tx *transaction) mirror(root, target string) string {
+	// targets are always joined to dir, so Rel doesn't fail
+	rel, _ := filepath.Rel(tx.dir, target)
+	return filepath.Join(root, rel)
+}
+
+// stage returns the path target is to be written to
+func (tx *transaction) stage(target string) string {
+	if !tx.isStaged[target] {
+		tx.isStaged[target] = true
+		tx.staged = append(tx.staged, target)
+	}
+	return tx.mirror(tx.staging, target)
+}
+
+// remove schedules target to be removed on commit,
+// dropping a staged version of it
+func (tx *transaction) remove(target string) error {
+	if tx.isStaged[target] {
+		delete(tx.isStaged, target)
+		if err := os.Remove(tx.mirror(tx.staging, target)); err != nil {
+			return newError(ErrInternal, target, "", err, "unable to remove staged file %s", target)
+		}
+	}
+	tx.deleted = append(tx.deleted, target)
+	return nil
+}
+
+// commit moves staged files into place and removes deleted ones.
+// If any of it fails, the destination directory is restored
+func (tx *transaction) commit() error {
+	err := tx.apply()
+	if err != nil {
+		tx.rollback()
+		return err
+	}
+	tx.committed = true
+	return nil
+}
+
+func (tx *transaction) apply() error {
+	for _, target := range tx.staged {
+		// a target dropped and staged again is listed twice
+		if !tx.isStaged[target] {
+			continue
+		}
+		delete(tx.isStaged, target)
+		if err := tx.backupTarget(target); err != nil {
+			return err
+		}
+		if err := tx.mkdirAll(filepath.Dir(target)); err != nil {
+			return err
+		}
+		if err := os.Rename(tx.mirror(tx.staging, target), target); err != nil {
+			return newError(ErrInvalidPath, target, "", err, "unable to move file %s into place", target)
+		}
+		tx.applied = append(tx.applied, target)
+	}
+	for _, target := range tx.deleted {
+		if err := tx.backupTarget(target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupTarget moves an existing target to the backup directory
+func (tx *transaction) backupTarget(target string) error {
+	if _, err := os.Lstat(target); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	backup := tx.mirror(tx.backup, target)
+	err := os.MkdirAll(filepath.Dir(backup), os.ModePerm)
+	if err == nil {
+		err = os.Rename(target, backup)
+	}
+	if err != nil {
+		return newError(ErrInternal, target, "", err, "unable to back up file %s", target)
+	}
+	tx.backups = append(tx.backups, target)
+	return nil
+}
+
+// mkdirAll creates directory dir remembering the topmost directory created
+func (tx *transaction) mkdirAll(dir string) error {
+	top := ""
+	for d := dir; d != tx.dir && d != filepath.Dir(d); d = filepath.Dir(d) {
+		if _, err := os.Lstat(d); errors.Is(err, fs.ErrNotExist) {
+			top = d
+		} else {
+			break
+		}
+	}
+	if top == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return newError(ErrInvalidPath, dir, "", err, "unable to create directory %s", dir)
+	}
+	tx.createdDirs = append(tx.createdDirs, top)
+	return nil
+}
+
+// rollback removes the files moved into place and restores backed up ones
+func (tx *transaction) rollback() {
+	for i := len(tx.applied) - 1; i >= 0; i-- {
+		os.Remove(tx.applied[i])
+	}
+	for i := len(tx.createdDirs) - 1; i >= 0; i-- {
+		os.RemoveAll(tx.createdDirs[i])
+	}
+	for i := len(tx.backups) - 1; i >= 0; i-- {
+		target := tx.backups[i]
+		os.MkdirAll(filepath.Dir(target), os.ModePerm)
+		os.Rename(tx.mirror(tx.backup, target), target)
+	}
+}
+
+// close removes the staging and backup directories, and the destination
+// directory if it was created by the transaction that is not committed
+func (tx *transaction) close() {
+	if tx.staging != "" {
+		os.RemoveAll(tx.staging)
+	}
+	if tx.backup != "" {
+		os.RemoveAll(tx.backup)
+	}
+	if tx.createdDir && !tx.committed {
+		os.RemoveAll(tx.dir)
+	}
+}
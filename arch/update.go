@@ -0,0 +1,145 @@
+package arch
+
+import (
+	"archive/zip"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Modes of Compress
+const (
+	// ModeCreate replaces the archive with a new one
+	ModeCreate = "create"
+	// ModeAppend keeps entries of the archive and adds files
+	// that are not in it yet
+	ModeAppend = "append"
+	// ModeUpdate keeps entries of the archive, adds new files
+	// and replaces entries of changed files
+	ModeUpdate = "update"
+)
+
+// Ways to detect changed files in ModeUpdate
+const (
+	// CompareMtime treats a file as changed if its size or
+	// modification time differs from the ones of the entry
+	CompareMtime = "mtime"
+	// CompareCRC treats a file as changed if its size or CRC-32
+	// differs from the ones of the entry
+	CompareCRC = "crc"
+)
+
+// existingArchive is the archive updated by Compress
+type existingArchive struct {
+	reader *zip.ReadCloser
+	// entries are the entries of the archive by name
+	entries map[string]*zip.File
+	// replaced are names of entries replaced by files
+	replaced map[string]bool
+}
+
+// openExisting opens req.ArchiveName to be updated.
+// It returns nil if a new archive is to be created
+func openExisting(req Request) (*existingArchive, error) {
+	switch req.Mode {
+	case "", ModeCreate:
+		return nil, nil
+	case ModeAppend, ModeUpdate:
+	default:
+		return nil, newError(ErrInvalidOption, "", "", nil, "unknown mode %s", req.Mode)
+	}
+	if err := checkCompare(req); err != nil {
+		return nil, err
+	}
+	reader, err := zip.OpenReader(req.ArchiveName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, newError(ErrCorruptArchive, req.ArchiveName, "", err,
+			"unable to open archive %s", req.ArchiveName)
+	}
+	entries := make(map[string]*zip.File, len(reader.File))
+	for _, f := range reader.File {
+		entries[f.Name] = f
+	}
+	return &existingArchive{reader: reader, entries: entries, replaced: map[string]bool{}}, nil
+}
+
+func checkCompare(req Request) error {
+	if req.Compare != "" && req.Compare != CompareMtime && req.Compare != CompareCRC {
+		return newError(ErrInvalidOption, "", "", nil, "unknown compare %s", req.Compare)
+	}
+	return nil
+}
+
+func (a *existingArchive) Close() error {
+	return a.reader.Close()
+}
+
+// skip returns the reason to leave out the file if it is already in the
+// archive and is not changed in ModeUpdate, and an empty string otherwise
+func (a *existingArchive) skip(req Request, file candidate) (string, error) {
+	f, ok := a.entries[file.name]
+	if !ok {
+		return "", nil
+	}
+	if req.Mode == ModeAppend {
+		return SkipExists, nil
+	}
+	changed, err := isChanged(file, int64(f.UncompressedSize64), f.Modified, f.CRC32, req.Compare)
+	if err != nil {
+		return "", err
+	}
+	if !changed {
+		return SkipUnchanged, nil
+	}
+	return "", nil
+}
+
+// replace makes the selected file replace its entry if it is in the archive
+func (a *existingArchive) replace(file candidate) {
+	if _, ok := a.entries[file.name]; ok {
+		a.replaced[file.name] = true
+	}
+}
+
+// kept returns entries of the archive that are not replaced.
+// The manifest is never kept as it describes the original archive
+func (a *existingArchive) kept() []*zip.File {
+	kept := make([]*zip.File, 0, len(a.reader.File))
+	for _, f := range a.reader.File {
+		if !a.replaced[f.Name] && f.Name != ManifestName {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// isChanged reports whether the file differs from its archived version
+// of the given size, modification time and CRC-32
+func isChanged(file candidate, size int64, modified time.Time, crc32Sum uint32, compare string) (bool, error) {
+	if file.info.Size() != size {
+		return true, nil
+	}
+	if compare != CompareCRC {
+		// zip keeps modification time in seconds
+		return file.info.ModTime().Unix() != modified.Unix(), nil
+	}
+
+	srcFile, err := os.Open(file.path)
+	if err != nil {
+		return false, newError(ErrInternal, file.path, "", err,
+			"unable to open file %s to compare", file.path)
+	}
+	defer srcFile.Close()
+	crc := crc32.NewIEEE()
+	if _, err := io.Copy(crc, srcFile); err != nil {
+		return false, newError(ErrInternal, file.path, "", err,
+			"unable to read file %s", file.path)
+	}
+	return crc.Sum32() != crc32Sum, nil
+}
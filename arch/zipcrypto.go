@@ -0,0 +1,89 @@
+package arch
+
+import (
+	"archive/zip"
+	"hash/crc32"
+	"io"
+)
+
+// Traditional PKWARE encryption, see section 6.1 of APPNOTE.TXT.
+// It is weak, so entries are only decrypted and never encrypted with it
+const (
+	// zipCryptoHeaderSize is the size of the encryption header
+	// preceding the data of an entry
+	zipCryptoHeaderSize = 12
+	// dataDescriptorFlag is set for entries whose sizes and CRC follow
+	// the data, the encryption header is checked against the time then
+	dataDescriptorFlag = 0x8
+)
+
+// zipCryptoKeys is the state of the ZipCrypto cipher
+type zipCryptoKeys [3]uint32
+
+func newZipCryptoKeys(password string) *zipCryptoKeys {
+	keys := &zipCryptoKeys{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(password); i++ {
+		keys.update(password[i])
+	}
+	return keys
+}
+
+// crc32Update is CRC-32 of one byte without the pre and post conditioning
+// of crc32.Update, as ZipCrypto defines it
+func crc32Update(crc uint32, b byte) uint32 {
+	return crc32.IEEETable[byte(crc)^b] ^ crc>>8
+}
+
+func (k *zipCryptoKeys) update(b byte) {
+	k[0] = crc32Update(k[0], b)
+	k[1] = (k[1]+k[0]&0xff)*134775813 + 1
+	k[2] = crc32Update(k[2], byte(k[1]>>24))
+}
+
+func (k *zipCryptoKeys) decrypt(p []byte) {
+	for i, c := range p {
+		temp := k[2] | 2
+		p[i] = c ^ byte(temp*(temp^1)>>8)
+		k.update(p[i])
+	}
+}
+
+// zipCryptoReader decrypts data of an entry
+type zipCryptoReader struct {
+	r    io.Reader
+	keys *zipCryptoKeys
+}
+
+func (zr *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := zr.r.Read(p)
+	zr.keys.decrypt(p[:n])
+	return n, err
+}
+
+// newZipCryptoReader returns a reader of the compressed data of entry f
+// read from raw. It fails with ErrWrongPassword if the decrypted header
+// doesn't match the entry
+func newZipCryptoReader(archiveName string, f *zip.File, raw io.Reader, password string) (io.Reader, error) {
+	if f.CompressedSize64 < zipCryptoHeaderSize {
+		return nil, newError(ErrCorruptArchive, archiveName, f.Name, nil,
+			"encrypted entry %s is truncated", f.Name)
+	}
+	header := make([]byte, zipCryptoHeaderSize)
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, newError(ErrCorruptArchive, archiveName, f.Name, err,
+			"unable to read encrypted entry %s", f.Name)
+	}
+	keys := newZipCryptoKeys(password)
+	keys.decrypt(header)
+	// the last byte of the header is the high byte of CRC, or of the time
+	// if CRC is not known before the data is written
+	check := byte(f.CRC32 >> 24)
+	if f.Flags&dataDescriptorFlag != 0 {
+		check = byte(f.ModifiedTime >> 8)
+	}
+	if header[zipCryptoHeaderSize-1] != check {
+		return nil, newError(ErrWrongPassword, archiveName, f.Name, nil,
+			"wrong password for entry %s", f.Name)
+	}
+	return &zipCryptoReader{r: io.LimitReader(raw, int64(f.CompressedSize64)-zipCryptoHeaderSize), keys: keys}, nil
+}
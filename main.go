@@ -8,6 +8,6 @@ import (
 
 func main() {
 	srv := http.Server{}
-	server := server.NewServer(&srv)
+	server := server.NewServer(&srv, server.ConfigFromEnv())
 	server.Serve()
 }
@@ -0,0 +1,321 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram buckets used for latencies, in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry all metrics of the service are registered in
+var Default = NewRegistry()
+
+type collector interface {
+	write(w io.Writer) error
+}
+
+// Registry keeps a set of metrics and renders them in Prometheus text format
+type Registry struct {
+	collectors []collector
+	mutex      sync.Mutex
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+// Write writes all registered metrics in Prometheus text exposition format
+func (r *Registry) Write(w io.Writer) error {
+	r.mutex.Lock()
+	collectors := make([]collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	r.mutex.Unlock()
+
+	for _, c := range collectors {
+		if err := c.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler returns an http.Handler serving the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(rw)
+	})
+}
+
+// desc holds the parts common to every metric family
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) header(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.typ)
+	return err
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (d *desc) labelPairs(key string, extra ...string) string {
+	pairs := make([]string, 0, len(d.labels)+len(extra)/2)
+	if len(d.labels) > 0 {
+		values := strings.Split(key, "\xff")
+		for i, l := range d.labels {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, l, escapeValue(values[i])))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escapeValue(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// valueVec is a set of float values partitioned by label values
+type valueVec struct {
+	desc
+	values map[string]float64
+	mutex  sync.Mutex
+}
+
+func (v *valueVec) add(delta float64, labelValues []string) {
+	key := v.key(labelValues)
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.values[key] += delta
+}
+
+func (v *valueVec) set(val float64, labelValues []string) {
+	key := v.key(labelValues)
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.values[key] = val
+}
+
+func (v *valueVec) write(w io.Writer) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if err := v.header(w); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(v.values) {
+		_, err := fmt.Fprintf(w, "%s%s %s\n", v.name, v.labelPairs(key), formatFloat(v.values[key]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CounterVec is a monotonically increasing value partitioned by labels
+type CounterVec struct {
+	valueVec
+}
+
+// NewCounterVec creates a counter and registers it in the Default registry
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{valueVec{
+		desc:   desc{name: name, help: help, typ: "counter", labels: labels},
+		values: make(map[string]float64),
+	}}
+	Default.register(c)
+	return c
+}
+
+// Inc increments the counter by one
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.add(1, labelValues)
+}
+
+// Add increases the counter by delta, which must not be negative
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", c.name))
+	}
+	c.add(delta, labelValues)
+}
+
+// GaugeVec is a value that can go up and down partitioned by labels
+type GaugeVec struct {
+	valueVec
+}
+
+// NewGaugeVec creates a gauge and registers it in the Default registry
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{valueVec{
+		desc:   desc{name: name, help: help, typ: "gauge", labels: labels},
+		values: make(map[string]float64),
+	}}
+	Default.register(g)
+	return g
+}
+
+// Inc increments the gauge by one
+func (g *GaugeVec) Inc(labelValues ...string) {
+	g.add(1, labelValues)
+}
+
+// Dec decrements the gauge by one
+func (g *GaugeVec) Dec(labelValues ...string) {
+	g.add(-1, labelValues)
+}
+
+// Set sets the gauge to val
+func (g *GaugeVec) Set(val float64, labelValues ...string) {
+	g.set(val, labelValues)
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec counts observations in buckets partitioned by labels
+type HistogramVec struct {
+	desc
+	buckets    []float64
+	histograms map[string]*histogram
+	mutex      sync.Mutex
+}
+
+// NewHistogramVec creates a histogram and registers it in the Default registry.
+// DefaultBuckets are used if buckets is nil
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	b := make([]float64, len(buckets))
+	copy(b, buckets)
+	sort.Float64s(b)
+
+	h := &HistogramVec{
+		desc:       desc{name: name, help: help, typ: "histogram", labels: labels},
+		buckets:    b,
+		histograms: make(map[string]*histogram),
+	}
+	Default.register(h)
+	return h
+}
+
+// Observe adds a single observation
+func (h *HistogramVec) Observe(val float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	hist, ok := h.histograms[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.histograms[key] = hist
+	}
+	for i, upper := range h.buckets {
+		if val <= upper {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += val
+}
+
+func (h *HistogramVec) write(w io.Writer) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if err := h.header(w); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(h.histograms))
+	for key := range h.histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		hist := h.histograms[key]
+		for i, upper := range h.buckets {
+			_, err := fmt.Fprintf(w, "%s_bucket%s %d\n",
+				h.name, h.labelPairs(key, "le", formatFloat(upper)), hist.counts[i])
+			if err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			h.name, h.labelPairs(key, "le", "+Inf"), hist.count,
+			h.name, h.labelPairs(key), formatFloat(hist.sum),
+			h.name, h.labelPairs(key), hist.count)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	valueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeValue(s string) string {
+	return valueReplacer.Replace(s)
+}
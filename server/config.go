@@ -0,0 +1,118 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+
+	"github.com/12z/archivarius/arch"
+)
+
+const (
+	defaultMaxQueued = 100
+	// default extract limits let through any sane archive. The ratio
+	// is not checked for small entries, so only the size limit keeps
+	// archives of many small or moderately compressed entries from
+	// filling the disk
+	defaultMaxExtractBytes = 10 << 30
+	defaultMaxRatio        = 1000
+	defaultMaxEntries      = 100000
+	defaultMaxDepth        = 64
+
+	envRoots      = "ARCHIVARIUS_ROOTS"
+	envWorkers    = "ARCHIVARIUS_WORKERS"
+	envMaxQueued  = "ARCHIVARIUS_MAX_QUEUED"
+	envAdminToken = "ARCHIVARIUS_ADMIN_TOKEN"
+	envKeysDir    = "ARCHIVARIUS_KEYS_DIR"
+	envSigningKey = "ARCHIVARIUS_SIGNING_KEY"
+
+	envMaxConcurrency  = "ARCHIVARIUS_MAX_CONCURRENCY"
+	envMaxExtractBytes = "ARCHIVARIUS_MAX_EXTRACT_BYTES"
+	envMaxEntryBytes   = "ARCHIVARIUS_MAX_ENTRY_BYTES"
+	envMaxRatio        = "ARCHIVARIUS_MAX_RATIO"
+	envMaxEntries      = "ARCHIVARIUS_MAX_ENTRIES"
+	envMaxDepth        = "ARCHIVARIUS_MAX_DEPTH"
+)
+
+// Config is the configuration of the service
+type Config struct {
+	// Roots are directories archives and files are written to.
+	// Readiness probe fails if any of them is not writable
+	Roots []string
+	// Workers is the max number of async sessions running at the same time
+	Workers int
+	// MaxQueued is the number of async sessions waiting for a worker
+	// after which the service is reported as not ready
+	MaxQueued int
+	// AdminToken is the bearer token granting the admin scope.
+	// Debug endpoints are not registered if it is empty
+	AdminToken string
+	// KeysDir is the directory of keys requests refer to by key_ref.
+	// Every file in it is a key named by the file name
+	KeysDir string
+	// SigningKey is the PEM file of the PKCS #8 ed25519 private key
+	// archives are signed and their signatures are checked with
+	SigningKey string
+	// MaxConcurrency caps the number of files a request compresses
+	// or extracts at once. It is not capped if MaxConcurrency is 0
+	MaxConcurrency int
+	// ExtractLimits cap the limits of extract requests,
+	// so requests can only make them stricter
+	ExtractLimits arch.ExtractLimits
+}
+
+// DefaultConfig returns the configuration used when nothing is configured
+func DefaultConfig() Config {
+	return Config{
+		Workers:        runtime.NumCPU(),
+		MaxQueued:      defaultMaxQueued,
+		MaxConcurrency: runtime.NumCPU(),
+		ExtractLimits: arch.ExtractLimits{
+			MaxBytes:   defaultMaxExtractBytes,
+			MaxRatio:   defaultMaxRatio,
+			MaxEntries: defaultMaxEntries,
+			MaxDepth:   defaultMaxDepth,
+		},
+	}
+}
+
+// ConfigFromEnv reads configuration from environment variables,
+// falling back to DefaultConfig for the ones not set
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+
+	if roots := os.Getenv(envRoots); roots != "" {
+		cfg.Roots = filepath.SplitList(roots)
+	}
+	if workers, err := strconv.Atoi(os.Getenv(envWorkers)); err == nil && workers > 0 {
+		cfg.Workers = workers
+	}
+	if maxQueued, err := strconv.Atoi(os.Getenv(envMaxQueued)); err == nil && maxQueued > 0 {
+		cfg.MaxQueued = maxQueued
+	}
+	if n, err := strconv.Atoi(os.Getenv(envMaxConcurrency)); err == nil && n > 0 {
+		cfg.MaxConcurrency = n
+	}
+	cfg.AdminToken = os.Getenv(envAdminToken)
+	cfg.KeysDir = os.Getenv(envKeysDir)
+	cfg.SigningKey = os.Getenv(envSigningKey)
+
+	if n, err := strconv.ParseInt(os.Getenv(envMaxExtractBytes), 10, 64); err == nil && n > 0 {
+		cfg.ExtractLimits.MaxBytes = n
+	}
+	if n, err := strconv.ParseInt(os.Getenv(envMaxEntryBytes), 10, 64); err == nil && n > 0 {
+		cfg.ExtractLimits.MaxEntryBytes = n
+	}
+	if ratio, err := strconv.ParseFloat(os.Getenv(envMaxRatio), 64); err == nil && ratio > 0 {
+		cfg.ExtractLimits.MaxRatio = ratio
+	}
+	if n, err := strconv.Atoi(os.Getenv(envMaxEntries)); err == nil && n > 0 {
+		cfg.ExtractLimits.MaxEntries = n
+	}
+	if n, err := strconv.Atoi(os.Getenv(envMaxDepth)); err == nil && n > 0 {
+		cfg.ExtractLimits.MaxDepth = n
+	}
+
+	return cfg
+}
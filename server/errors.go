@@ -0,0 +1,57 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/12z/archivarius/arch"
+)
+
+// Error codes of failures detected before the request reaches arch.
+// Codes of processing failures are provided by arch.ErrorCode
+const (
+	codeInvalidRequest = "invalid_request"
+	codeInternal       = "internal"
+	codeNotFound       = "not_found"
+)
+
+// statusCodes maps kinds of arch errors to HTTP status codes.
+// Unknown kinds are reported as internal errors
+var statusCodes = map[error]int{
+	arch.ErrNotFound:         http.StatusBadRequest,
+	arch.ErrMalformedFilter:  http.StatusBadRequest,
+	arch.ErrPermission:       http.StatusBadRequest,
+	arch.ErrCorruptArchive:   http.StatusBadRequest,
+	arch.ErrInvalidPath:      http.StatusBadRequest,
+	arch.ErrInvalidOption:    http.StatusBadRequest,
+	arch.ErrNameCollision:    http.StatusBadRequest,
+	arch.ErrConflict:         http.StatusConflict,
+	arch.ErrLimitExceeded:    http.StatusBadRequest,
+	arch.ErrWrongPassword:    http.StatusBadRequest,
+	arch.ErrInvalidSignature: http.StatusBadRequest,
+}
+
+func httpStatus(err error) int {
+	for kind, code := range statusCodes {
+		if errors.Is(err, kind) {
+			return code
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// errorResponse describes an error returned by arch
+func errorResponse(err error) (int, Response) {
+	resp := Response{
+		Status:    "nok",
+		ErrorCode: arch.ErrorCode(err),
+		Message:   fmt.Sprintf("unable to process (%s)", err.Error()),
+	}
+	var archErr *arch.Error
+	if errors.As(err, &archErr) {
+		resp.Path = archErr.Path
+		resp.Entry = archErr.Entry
+	}
+	return httpStatus(err), resp
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"strings"
+)
+
+type RuntimeStats struct {
+	Goroutines  int    `json:"goroutines"`
+	CPUs        int    `json:"cpus"`
+	HeapAlloc   uint64 `json:"heap_alloc"`
+	HeapSys     uint64 `json:"heap_sys"`
+	HeapObjects uint64 `json:"heap_objects"`
+	TotalAlloc  uint64 `json:"total_alloc"`
+	NumGC       uint32 `json:"num_gc"`
+	Sessions    int    `json:"sessions"`
+	Queued      int    `json:"queued"`
+}
+
+func healthHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(rw, http.StatusOK, Response{Status: "ok"})
+}
+
+func readyHandler(rw http.ResponseWriter, r *http.Request, sm *SessionManager, cfg Config) {
+	if r.Method != "GET" {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var problems []string
+	for _, root := range cfg.Roots {
+		if err := checkWritable(root); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if _, err := cfg.signingKey(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if queued := sm.Queued(); cfg.MaxQueued > 0 && queued >= cfg.MaxQueued {
+		problems = append(problems,
+			fmt.Sprintf("job queue is saturated (%d of %d sessions queued)", queued, cfg.MaxQueued))
+	}
+
+	if len(problems) > 0 {
+		writeJSON(rw, http.StatusServiceUnavailable, Response{
+			Status:  "nok",
+			Message: strings.Join(problems, "; "),
+		})
+		return
+	}
+	writeJSON(rw, http.StatusOK, Response{Status: "ok"})
+}
+
+// checkWritable makes sure a file can be created in dir
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return fmt.Errorf("root %s is not writable (%w)", dir, err)
+	}
+	name := f.Name()
+	f.Close()
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("unable to clean up root %s (%w)", dir, err)
+	}
+	return nil
+}
+
+func runtimeHandler(rw http.ResponseWriter, r *http.Request, sm *SessionManager) {
+	if r.Method != "GET" {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	writeJSON(rw, http.StatusOK, RuntimeStats{
+		Goroutines:  runtime.NumGoroutine(),
+		CPUs:        runtime.NumCPU(),
+		HeapAlloc:   mem.HeapAlloc,
+		HeapSys:     mem.HeapSys,
+		HeapObjects: mem.HeapObjects,
+		TotalAlloc:  mem.TotalAlloc,
+		NumGC:       mem.NumGC,
+		Sessions:    sm.Len(),
+		Queued:      sm.Queued(),
+	})
+}
+
+// debugHandler serves pprof and runtime stats to callers with the admin scope
+func debugHandler(sm *SessionManager, cfg Config) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/runtime", func(w http.ResponseWriter, r *http.Request) {
+		runtimeHandler(w, r, sm)
+	})
+
+	return requireAdmin(cfg.AdminToken, mux)
+}
+
+func requireAdmin(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			writeJSON(rw, http.StatusUnauthorized, Response{
+				Status:  "nok",
+				Message: "admin scope required",
+			})
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+func writeJSON(rw http.ResponseWriter, statusCode int, resp interface{}) {
+	respData, err := json.Marshal(resp)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(statusCode)
+	rw.Write(respData)
+}
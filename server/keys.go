@@ -0,0 +1,25 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// key returns the key named ref in cfg.KeysDir. Errors don't tell
+// where keys are kept, as they are reported to clients
+func (cfg Config) key(ref string) (string, error) {
+	if cfg.KeysDir == "" {
+		return "", fmt.Errorf("no keys are configured")
+	}
+	if ref == "." || ref == ".." || strings.ContainsAny(ref, `/\`) {
+		return "", fmt.Errorf("malformed key name %q", ref)
+	}
+	data, err := os.ReadFile(filepath.Join(cfg.KeysDir, ref))
+	if err != nil {
+		return "", fmt.Errorf("key %q is not configured", ref)
+	}
+	// keys are usually written with a trailing newline
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/12z/archivarius/metrics"
+)
+
+var (
+	requestsTotal = metrics.NewCounterVec("archivarius_http_requests_total",
+		"HTTP requests processed, by endpoint, method and status code",
+		"endpoint", "method", "code")
+	requestDuration = metrics.NewHistogramVec("archivarius_http_request_duration_seconds",
+		"HTTP request latencies, by endpoint, method and status code",
+		nil, "endpoint", "method", "code")
+	sessionsGauge = metrics.NewGaugeVec("archivarius_sessions",
+		"Async sessions, by state", "state")
+)
+
+// sessionStates maps session statuses to the state label of sessionsGauge
+var sessionStates = map[string]string{
+	Created:  "queued",
+	Started:  "active",
+	Finished: "finished",
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// instrument wraps a handler to count requests and measure latencies.
+// endpoint is used as a label instead of the URL to keep cardinality bounded
+func instrument(endpoint string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: rw, code: http.StatusOK}
+		handler(rec, r)
+
+		code := strconv.Itoa(rec.code)
+		requestsTotal.Inc(endpoint, r.Method, code)
+		requestDuration.Observe(time.Since(start).Seconds(), endpoint, r.Method, code)
+	}
+}
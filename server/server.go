@@ -1,12 +1,14 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 
 	"github.com/12z/archivarius/arch"
+	"github.com/12z/archivarius/metrics"
 )
 
 const apiPrefix = "/api/v1"
@@ -17,13 +19,19 @@ type Server struct {
 }
 
 type Response struct {
-	Status  string `json:"status"`
-	Message string `json:"message,omitempty"`
+	Status    string `json:"status"`
+	ErrorCode string `json:"error_code,omitempty"`
+	Message   string `json:"message,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Entry     string `json:"entry,omitempty"`
+	// Result describes processed files of a successful operation
+	Result *arch.Result `json:"result,omitempty"`
 }
 
 type AsyncResult struct {
-	Code     int      `json:"status_code,omitempty"`
-	Response Response `json:"response,omitempty"`
+	Code      int      `json:"status_code,omitempty"`
+	ErrorCode string   `json:"error_code,omitempty"`
+	Response  Response `json:"response,omitempty"`
 }
 
 type AsyncGetResponse struct {
@@ -31,16 +39,20 @@ type AsyncGetResponse struct {
 	Result AsyncResult `json:"result,omitempty"`
 }
 
+// processor is an operation of arch served by the handlers
+type processor func(ctx context.Context, req arch.Request) (arch.Result, error)
+
 type AsyncPostResponse struct {
 	SessionId string `json:"session_id"`
 	Status    string `json:"status"`
+	ErrorCode string `json:"error_code,omitempty"`
 	Message   string `json:"message,omitempty"`
 }
 
 // NewServer creates an instance of Server
-func NewServer(srv *http.Server) *Server {
-	sm := NewSessionManager()
-	srv.Handler = Router(sm)
+func NewServer(srv *http.Server, cfg Config) *Server {
+	sm := NewSessionManager(cfg)
+	srv.Handler = Router(sm, cfg)
 	server := &Server{
 		server: srv,
 		sm:     sm,
@@ -49,19 +61,53 @@ func NewServer(srv *http.Server) *Server {
 	return server
 }
 
-func Router(sm *SessionManager) *http.ServeMux {
+func Router(sm *SessionManager, cfg Config) *http.ServeMux {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc(fmt.Sprintf("%s/compress", apiPrefix), compressHandler)
-	mux.HandleFunc(fmt.Sprintf("%s/extract", apiPrefix), extractHandler)
-	mux.HandleFunc(fmt.Sprintf("%s/compress/async", apiPrefix),
+	handle := func(path string, handler http.HandlerFunc) {
+		mux.HandleFunc(path, instrument(path, handler))
+	}
+
+	handle(fmt.Sprintf("%s/compress", apiPrefix),
+		func(w http.ResponseWriter, r *http.Request) {
+			compressHandler(w, r, cfg)
+		})
+	handle(fmt.Sprintf("%s/extract", apiPrefix),
+		func(w http.ResponseWriter, r *http.Request) {
+			extractHandler(w, r, cfg)
+		})
+	handle(fmt.Sprintf("%s/list", apiPrefix),
+		func(w http.ResponseWriter, r *http.Request) {
+			listHandler(w, r, cfg)
+		})
+	handle(fmt.Sprintf("%s/verify", apiPrefix),
+		func(w http.ResponseWriter, r *http.Request) {
+			verifyHandler(w, r, cfg)
+		})
+	handle(fmt.Sprintf("%s/public-key", apiPrefix),
+		func(w http.ResponseWriter, r *http.Request) {
+			publicKeyHandler(w, r, cfg)
+		})
+	handle(fmt.Sprintf("%s/compress/async", apiPrefix),
+		func(w http.ResponseWriter, r *http.Request) {
+			compressHandlerAsync(w, r, sm, cfg)
+		})
+	handle(fmt.Sprintf("%s/extract/async", apiPrefix),
 		func(w http.ResponseWriter, r *http.Request) {
-			compressHandlerAsync(w, r, sm)
+			extractHandlerAsync(w, r, sm, cfg)
 		})
-	mux.HandleFunc(fmt.Sprintf("%s/extract/async", apiPrefix),
+	handle(fmt.Sprintf("%s/verify/async", apiPrefix),
 		func(w http.ResponseWriter, r *http.Request) {
-			extractHandlerAsync(w, r, sm)
+			verifyHandlerAsync(w, r, sm, cfg)
 		})
+	mux.Handle("/metrics", metrics.Default.Handler())
+	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		readyHandler(w, r, sm, cfg)
+	})
+	if cfg.AdminToken != "" {
+		mux.Handle("/debug/", debugHandler(sm, cfg))
+	}
 
 	return mux
 }
@@ -71,22 +117,51 @@ func (s *Server) Serve() {
 	s.server.ListenAndServe()
 }
 
-func compressHandler(rw http.ResponseWriter, r *http.Request) {
-	syncHandler(rw, r, arch.Compress)
+func compressHandler(rw http.ResponseWriter, r *http.Request, cfg Config) {
+	syncHandler(rw, r, configured(cfg, arch.Compress))
+}
+
+func extractHandler(rw http.ResponseWriter, r *http.Request, cfg Config) {
+	syncHandler(rw, r, configured(cfg, arch.Extract))
+}
+
+func listHandler(rw http.ResponseWriter, r *http.Request, cfg Config) {
+	syncHandler(rw, r, configured(cfg, arch.List))
 }
 
-func extractHandler(rw http.ResponseWriter, r *http.Request) {
-	syncHandler(rw, r, arch.Extract)
+func verifyHandler(rw http.ResponseWriter, r *http.Request, cfg Config) {
+	syncHandler(rw, r, configured(cfg, arch.Verify))
+}
+
+// configured returns the processor with extract limits and concurrency
+// of requests capped by the configured ones, key references resolved
+// to the configured keys and the signing key set if requests need it
+func configured(cfg Config, process processor) processor {
+	return func(ctx context.Context, req arch.Request) (arch.Result, error) {
+		req.ExtractLimits = req.ExtractLimits.Cap(cfg.ExtractLimits)
+		if cfg.MaxConcurrency > 0 && (req.Concurrency <= 0 || req.Concurrency > cfg.MaxConcurrency) {
+			req.Concurrency = cfg.MaxConcurrency
+		}
+		req.Keys = cfg.key
+		if req.Sign != "" || req.RequireSignature {
+			key, err := cfg.signingKey()
+			if err != nil {
+				return arch.Result{}, err
+			}
+			req.SigningKey = key
+		}
+		return process(ctx, req)
+	}
 }
 
 func syncHandler(rw http.ResponseWriter, r *http.Request,
-	processor func(req arch.Request) (int, error),
+	processor processor,
 ) {
 	if r.Method != "POST" {
 		rw.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	statusCode, resp := processSync(r.Body, processor)
+	statusCode, resp := processSync(r.Context(), r.Body, processor)
 	respData, err := json.Marshal(resp)
 	if err != nil {
 		rw.WriteHeader(http.StatusInternalServerError)
@@ -96,7 +171,7 @@ func syncHandler(rw http.ResponseWriter, r *http.Request,
 	rw.Write(respData)
 }
 
-func processSync(r io.ReadCloser, processor func(req arch.Request) (int, error)) (int, Response) {
+func processSync(ctx context.Context, r io.ReadCloser, processor processor) (int, Response) {
 	var statusCode = 200
 	var resp Response
 
@@ -104,6 +179,7 @@ func processSync(r io.ReadCloser, processor func(req arch.Request) (int, error))
 	if err != nil {
 		statusCode = 500
 		resp.Status = "nok"
+		resp.ErrorCode = codeInternal
 		resp.Message = "unable to read request"
 		return statusCode, resp
 	}
@@ -114,40 +190,42 @@ func processSync(r io.ReadCloser, processor func(req arch.Request) (int, error))
 	if err != nil {
 		statusCode = 400
 		resp.Status = "nok"
+		resp.ErrorCode = codeInvalidRequest
 		resp.Message = fmt.Sprintf("incorrect rquest format (%s)", err.Error())
 		return statusCode, resp
 	}
 
-	stCode, err := processor(req)
+	res, err := processor(ctx, req)
 	if err != nil {
-		statusCode = stCode
-		resp.Status = "nok"
-		resp.Message = fmt.Sprintf("unable to process (%s)", err.Error())
-		return statusCode, resp
+		return errorResponse(err)
 	}
 
 	resp.Status = "ok"
+	resp.Result = &res
 
 	return statusCode, resp
 }
 
-func compressHandlerAsync(rw http.ResponseWriter, r *http.Request, sm *SessionManager) {
-	handlerAsync(rw, r, sm, arch.Compress)
+func compressHandlerAsync(rw http.ResponseWriter, r *http.Request, sm *SessionManager, cfg Config) {
+	handlerAsync(rw, r, sm, configured(cfg, arch.Compress))
+}
+
+func extractHandlerAsync(rw http.ResponseWriter, r *http.Request, sm *SessionManager, cfg Config) {
+	handlerAsync(rw, r, sm, configured(cfg, arch.Extract))
 }
 
-func extractHandlerAsync(rw http.ResponseWriter, r *http.Request, sm *SessionManager) {
-	handlerAsync(rw, r, sm, arch.Extract)
+func verifyHandlerAsync(rw http.ResponseWriter, r *http.Request, sm *SessionManager, cfg Config) {
+	handlerAsync(rw, r, sm, configured(cfg, arch.Verify))
 }
 
 func handlerAsync(rw http.ResponseWriter, r *http.Request, sm *SessionManager,
-	processor func(req arch.Request) (int, error),
+	processor processor,
 ) {
 	switch r.Method {
 	case "POST":
-		session_id, session := sm.CreateSession()
-		statusCode, resp := processAsync(r.Body, session, processor)
+		session_id, statusCode, resp := processAsync(r.Body, sm, processor)
 
-		pResp := AsyncPostResponse{session_id, resp.Status, resp.Message}
+		pResp := AsyncPostResponse{session_id, resp.Status, resp.ErrorCode, resp.Message}
 		respData, err := json.Marshal(pResp)
 		if err != nil {
 			rw.WriteHeader(http.StatusInternalServerError)
@@ -182,9 +260,11 @@ func handlerAsync(rw http.ResponseWriter, r *http.Request, sm *SessionManager,
 	}
 }
 
-func processAsync(r io.ReadCloser, session *Session,
-	processor func(req arch.Request) (int, error),
-) (int, Response) {
+// processAsync parses the request and runs it in a new session.
+// No session is created for requests that can't be parsed
+func processAsync(r io.ReadCloser, sm *SessionManager,
+	processor processor,
+) (string, int, Response) {
 	var statusCode = 200
 	var resp Response
 
@@ -192,8 +272,9 @@ func processAsync(r io.ReadCloser, session *Session,
 	if err != nil {
 		statusCode = 500
 		resp.Status = "nok"
+		resp.ErrorCode = codeInternal
 		resp.Message = "unable to read request"
-		return statusCode, resp
+		return "", statusCode, resp
 	}
 	defer r.Close()
 
@@ -202,13 +283,15 @@ func processAsync(r io.ReadCloser, session *Session,
 	if err != nil {
 		statusCode = 400
 		resp.Status = "nok"
+		resp.ErrorCode = codeInvalidRequest
 		resp.Message = fmt.Sprintf("incorrect rquest format (%s)", err.Error())
-		return statusCode, resp
+		return "", statusCode, resp
 	}
 
+	sessionId, session := sm.CreateSession()
 	go session.Run(req, processor)
 
 	resp.Status = "ok"
 
-	return statusCode, resp
+	return sessionId, statusCode, resp
 }
@@ -1,7 +1,7 @@
 package server
 
 import (
-	"fmt"
+	"context"
 	"sync"
 
 	"github.com/google/uuid"
@@ -19,28 +19,63 @@ type Session struct {
 	status string
 	result AsyncResult
 	mutex  sync.Mutex
+	// workers is shared by sessions of a manager to bound how many run at once
+	workers chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	// deleted sessions are no longer accounted in metrics
+	deleted bool
 }
 
-func (s *Session) Run(req arch.Request, processor func(req arch.Request) (int, error)) {
-	s.mutex.Lock()
-	s.status = Started
-	s.mutex.Unlock()
+// Run waits for a free worker and processes the request.
+// Until a worker is available the session stays in Created status.
+// A session deleted while waiting is not processed at all
+func (s *Session) Run(req arch.Request, processor processor) {
+	if s.workers != nil {
+		select {
+		case s.workers <- struct{}{}:
+			defer func() { <-s.workers }()
+		case <-s.ctx.Done():
+			return
+		}
+	}
+	s.setStatus(Started)
 
+	var statusCode = 200
 	var resp = Response{
 		Status: "ok",
 	}
-	statusCode, err := processor(req)
+	res, err := processor(s.ctx, req)
 	if err != nil {
-		resp.Status = "nok"
-		resp.Message = fmt.Sprintf("unable to process (%s)", err.Error())
+		statusCode, resp = errorResponse(err)
+	} else {
+		resp.Result = &res
 	}
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	s.result.Code = statusCode
+	s.result.ErrorCode = resp.ErrorCode
 	s.result.Response = resp
-	s.status = Finished
+	s.setStatusLocked(Finished)
+}
+
+func (s *Session) setStatus(status string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.setStatusLocked(status)
+}
+
+// setStatusLocked changes status and keeps the sessions gauge in sync.
+// The caller must hold s.mutex
+func (s *Session) setStatusLocked(status string) {
+	if !s.deleted {
+		sessionsGauge.Dec(sessionStates[s.status])
+		sessionsGauge.Inc(sessionStates[status])
+	}
+	s.status = status
 }
 
 func (s *Session) Result() (string, AsyncResult) {
@@ -52,13 +87,20 @@ func (s *Session) Result() (string, AsyncResult) {
 
 type SessionManager struct {
 	sessions map[string]*Session
+	workers  chan struct{}
 	mutex    sync.Mutex
 }
 
-func NewSessionManager() *SessionManager {
-	return &SessionManager{
+// NewSessionManager creates a SessionManager running at most cfg.Workers
+// sessions at the same time. The number is unbounded if cfg.Workers is 0
+func NewSessionManager(cfg Config) *SessionManager {
+	m := &SessionManager{
 		sessions: make(map[string]*Session),
 	}
+	if cfg.Workers > 0 {
+		m.workers = make(chan struct{}, cfg.Workers)
+	}
+	return m
 }
 
 func (m *SessionManager) Get(id string) *Session {
@@ -73,14 +115,56 @@ func (m *SessionManager) CreateSession() (string, *Session) {
 	defer m.mutex.Unlock()
 
 	id := uuid.New().String()
+	ctx, cancel := context.WithCancel(context.Background())
 	session := &Session{
-		status: Created,
+		status:  Created,
+		workers: m.workers,
+		ctx:     ctx,
+		cancel:  cancel,
 	}
 	m.sessions[id] = session
+	sessionsGauge.Inc(sessionStates[Created])
 
 	return id, session
 }
 
+// Delete removes the session, canceling it if it is not finished yet
 func (m *SessionManager) Delete(id string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return
+	}
+	session.cancel()
+	session.mutex.Lock()
+	sessionsGauge.Dec(sessionStates[session.status])
+	session.deleted = true
+	session.mutex.Unlock()
 	delete(m.sessions, id)
 }
+
+// Len returns the number of sessions kept by the manager
+func (m *SessionManager) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return len(m.sessions)
+}
+
+// Queued returns the number of sessions waiting for a worker
+func (m *SessionManager) Queued() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	queued := 0
+	for _, session := range m.sessions {
+		session.mutex.Lock()
+		if session.status == Created {
+			queued++
+		}
+		session.mutex.Unlock()
+	}
+	return queued
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// signingKey returns the configured signing key, which is read on every
+// request, so it can be rotated without a restart. Errors don't tell
+// where the key is kept, as they are reported to clients
+func (cfg Config) signingKey() (ed25519.PrivateKey, error) {
+	if cfg.SigningKey == "" {
+		return nil, nil
+	}
+	key, err := loadSigningKey(cfg.SigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("signing key is not available")
+	}
+	return key, nil
+}
+
+// loadSigningKey reads the PEM file of a PKCS #8 ed25519 private key
+func loadSigningKey(filename string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read signing key (%w)", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("signing key %s is not a PEM private key", filename)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse signing key %s (%w)", filename, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not an ed25519 key", filename)
+	}
+	return key, nil
+}
+
+// publicKeyHandler serves the public key of the signing key as PEM,
+// so consumers can verify signatures of archives offline
+func publicKeyHandler(rw http.ResponseWriter, r *http.Request, cfg Config) {
+	if r.Method != "GET" {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if cfg.SigningKey == "" {
+		writeJSON(rw, http.StatusNotFound, Response{
+			Status:    "nok",
+			ErrorCode: codeNotFound,
+			Message:   "no signing key is configured",
+		})
+		return
+	}
+	key, err := cfg.signingKey()
+	if err != nil {
+		writeJSON(rw, http.StatusInternalServerError, Response{
+			Status:    "nok",
+			ErrorCode: codeInternal,
+			Message:   err.Error(),
+		})
+		return
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/x-pem-file")
+	pem.Encode(rw, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
@@ -0,0 +1,752 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/12z/archivarius/arch"
+)
+
+func TestLibrary(t *testing.T) {
+	setupTestBasicData(t, []int{1, 2, 3, 21})
+	defer teardownTestBasicData(t)
+	ctx := context.Background()
+
+	res, err := arch.Compress(ctx, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+		Filter:      "*.txt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Files != 3 || res.Bytes != 6 {
+		t.Fatalf("expected 3 files of 6 bytes compressed, got %d files of %d bytes", res.Files, res.Bytes)
+	}
+	if res.CompressedBytes == 0 || res.Ratio == 0 {
+		t.Fatalf("compressed size is not reported: %+v", res)
+	}
+
+	res, err = arch.Extract(ctx, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/dst",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Files != 3 || res.Bytes != 6 {
+		t.Fatalf("expected 3 files of 6 bytes extracted, got %d files of %d bytes", res.Files, res.Bytes)
+	}
+
+	_, err = arch.Extract(ctx, arch.Request{
+		ArchiveName: ".tmp/test/missing.zip",
+		Directory:   ".tmp/test/dst",
+	})
+	if !errors.Is(err, arch.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	var archErr *arch.Error
+	if !errors.As(err, &archErr) || archErr.Path != ".tmp/test/missing.zip" {
+		t.Fatalf("expected error with path of the archive, got %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = arch.Extract(canceled, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/dst",
+	})
+	if !errors.Is(err, arch.ErrCanceled) {
+		t.Fatalf("expected ErrCanceled, got %v", err)
+	}
+}
+
+func TestSortByMtime(t *testing.T) {
+	setupTestBasicData(t, []int{1, 2, 3, 4})
+	defer teardownTestBasicData(t)
+
+	// one.txt is the newest, four.txt is the oldest
+	now := time.Now()
+	for i, ind := range []int{1, 2, 3, 4} {
+		mtime := now.Add(-time.Duration(i) * time.Hour)
+		if err := os.Chtimes(files[ind].name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := arch.Compress(context.Background(), arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+		Limit:       2,
+		Sort:        arch.SortMtimeDesc,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Entries) != 2 ||
+		res.Entries[0].Path != files[1].name || res.Entries[1].Path != files[2].name {
+		t.Fatalf("expected two newest files, got %+v", res.Entries)
+	}
+
+	_, err = arch.Compress(context.Background(), arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+		Sort:        "random",
+	})
+	if !errors.Is(err, arch.ErrInvalidOption) {
+		t.Fatalf("expected ErrInvalidOption, got %v", err)
+	}
+}
+
+func TestBudgets(t *testing.T) {
+	setupTestBasicData(t, []int{1, 2, 3, 4, 5, 6})
+	defer teardownTestBasicData(t)
+	ctx := context.Background()
+
+	res, err := arch.Compress(ctx, arch.Request{
+		ArchiveName:   ".tmp/test/archive.zip",
+		Directory:     ".tmp/test/src",
+		MaxTotalBytes: 12,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// six.txt and five.txt fit, one.txt would fit as well but no files
+	// are added after four.txt exceeds the budget
+	if res.Files != 2 || res.Bytes != 11 {
+		t.Fatalf("expected 2 files of 11 bytes, got %d files of %d bytes", res.Files, res.Bytes)
+	}
+	for _, s := range res.Skipped {
+		if s.Reason != arch.SkipBudget {
+			t.Fatalf("expected %s to be skipped by budget, got %s", s.Path, s.Reason)
+		}
+	}
+	if len(res.Skipped) != 4 {
+		t.Fatalf("expected 4 files skipped, got %v", res.Skipped)
+	}
+
+	const maxArchiveBytes = 600
+	res, err = arch.Compress(ctx, arch.Request{
+		ArchiveName:     ".tmp/test/archive.zip",
+		Directory:       ".tmp/test/src",
+		MaxArchiveBytes: maxArchiveBytes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(".tmp/test/archive.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > maxArchiveBytes {
+		t.Fatalf("archive of %d bytes exceeds the budget", info.Size())
+	}
+	if res.Files != 2 || len(res.Skipped) != 4 || res.Skipped[0].Reason != arch.SkipBudget {
+		t.Fatalf("expected 2 files compressed and 4 left out by budget, got %+v", res)
+	}
+
+	// the manifest counts as well
+	for _, limit := range []int64{400, 600, 1000, 2000} {
+		res, err = arch.Compress(ctx, arch.Request{
+			ArchiveName:     ".tmp/test/archive.zip",
+			Directory:       ".tmp/test/src",
+			MaxArchiveBytes: limit,
+			Manifest:        true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(".tmp/test/archive.zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > limit {
+			t.Fatalf("archive with manifest of %d bytes exceeds the budget of %d", info.Size(), limit)
+		}
+	}
+}
+
+func TestSources(t *testing.T) {
+	setupTestBasicData(t, []int{1, 2, 3, 21, 101, 102})
+	defer teardownTestBasicData(t)
+	ctx := context.Background()
+
+	res, err := arch.Compress(ctx, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Sources: []arch.Source{
+			{Path: ".tmp/test/src", Prefix: "top", Filter: "*.txt", Limit: 2},
+			{Path: ".tmp/test/src/inner", Prefix: "nested"},
+			{Path: files[21].name},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expNames := []string{"top/three.txt", "top/two.txt", "nested/inner1.txt", files[21].name}
+	actNames := make([]string, 0, len(res.Entries))
+	for _, entry := range res.Entries {
+		actNames = append(actNames, entry.Name)
+	}
+	if !fileListsEqual(expNames, actNames) {
+		t.Fatalf("wrong entries: expected %s, got %s", expNames, actNames)
+	}
+
+	res, err = arch.Extract(ctx, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/dst",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Files != len(expNames) {
+		t.Fatalf("expected %d files extracted, got %d", len(expNames), res.Files)
+	}
+
+	_, err = arch.Compress(ctx, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Sources: []arch.Source{
+			{Path: files[1].name, Prefix: "same"},
+			{Path: ".tmp/test/src", Prefix: "same", Filter: "one.txt"},
+		},
+	})
+	if !errors.Is(err, arch.ErrNameCollision) {
+		t.Fatalf("expected ErrNameCollision, got %v", err)
+	}
+}
+
+func TestNaming(t *testing.T) {
+	setupTestBasicData(t, []int{1, 2, 101, 102})
+	defer teardownTestBasicData(t)
+	ctx := context.Background()
+
+	res, err := arch.Compress(ctx, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+		Recursive:   true,
+		Naming:      arch.NamingRelative,
+		Sort:        arch.SortName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expNames := []string{"inner/inner/inner2.txt", "inner/inner1.txt", "one.txt", "two.txt"}
+	for i, entry := range res.Entries {
+		if entry.Name != expNames[i] {
+			t.Fatalf("expected entry %d to be named %s, got %s", i, expNames[i], entry.Name)
+		}
+	}
+
+	res, err = arch.Extract(ctx, arch.Request{
+		ArchiveName:     ".tmp/test/archive.zip",
+		Directory:       ".tmp/test/dst",
+		StripComponents: 1,
+		Rename:          []arch.Rename{{Pattern: `^inner/(.*)\.txt$`, Replace: "deep/$1.log"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Files != 2 || len(res.Skipped) != 2 || res.Skipped[0].Reason != arch.SkipName {
+		t.Fatalf("expected 2 files extracted and 2 skipped by name, got %+v", res)
+	}
+	for _, p := range []string{".tmp/test/dst/deep/inner2.log", ".tmp/test/dst/inner1.txt"} {
+		if _, err := os.Stat(p); err != nil {
+			t.Fatalf("file is not extracted: %v", err)
+		}
+	}
+
+	res, err = arch.Compress(ctx, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+		Prefix:      "backup",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Entries[0].Name != "backup/two.txt" {
+		t.Fatalf("expected prefixed name backup/two.txt, got %s", res.Entries[0].Name)
+	}
+
+	_, err = arch.Extract(ctx, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/dst",
+		Rename:      []arch.Rename{{Pattern: "^backup", Replace: ".."}},
+	})
+	if !errors.Is(err, arch.ErrInvalidPath) {
+		t.Fatalf("expected ErrInvalidPath for entry leaving the directory, got %v", err)
+	}
+
+	_, err = arch.Compress(ctx, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+		Prefix:      "../up",
+	})
+	if !errors.Is(err, arch.ErrInvalidOption) {
+		t.Fatalf("expected ErrInvalidOption for prefix leaving the root, got %v", err)
+	}
+}
+
+func TestCompressionMethod(t *testing.T) {
+	setupTestBasicData(t, nil)
+	defer teardownTestBasicData(t)
+	ctx := context.Background()
+
+	random := make([]byte, 32*1024)
+	rand.Read(random)
+	text := bytes.Repeat([]byte("archivarius "), 4096)
+	data := map[string][]byte{
+		".tmp/test/src/random.bin": random,
+		".tmp/test/src/photo.jpg":  text,
+		".tmp/test/src/notes.txt":  text,
+	}
+	for name, content := range data {
+		if err := os.WriteFile(name, content, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		level      int
+		expMethods map[string]string
+	}{
+		{
+			name:   "auto",
+			method: arch.MethodAuto,
+			level:  9,
+			expMethods: map[string]string{
+				".tmp/test/src/random.bin": arch.MethodStore,
+				".tmp/test/src/photo.jpg":  arch.MethodStore,
+				".tmp/test/src/notes.txt":  arch.MethodDeflate,
+			},
+		},
+		{
+			name:   "store",
+			method: arch.MethodStore,
+			expMethods: map[string]string{
+				".tmp/test/src/random.bin": arch.MethodStore,
+				".tmp/test/src/photo.jpg":  arch.MethodStore,
+				".tmp/test/src/notes.txt":  arch.MethodStore,
+			},
+		},
+		{
+			name:  "deflate without compression",
+			level: 0,
+			expMethods: map[string]string{
+				".tmp/test/src/random.bin": arch.MethodDeflate,
+				".tmp/test/src/photo.jpg":  arch.MethodDeflate,
+				".tmp/test/src/notes.txt":  arch.MethodDeflate,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			level := tt.level
+			res, err := arch.Compress(ctx, arch.Request{
+				ArchiveName: ".tmp/test/archive.zip",
+				Directory:   ".tmp/test/src",
+				Method:      tt.method,
+				Level:       &level,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range res.Entries {
+				if entry.Method != tt.expMethods[entry.Path] {
+					t.Fatalf("expected %s to be compressed with %s, got %s",
+						entry.Path, tt.expMethods[entry.Path], entry.Method)
+				}
+				if entry.Method == arch.MethodStore && entry.CompressedSize != entry.Size {
+					t.Fatalf("stored %s has compressed size %d of %d", entry.Path, entry.CompressedSize, entry.Size)
+				}
+			}
+
+			_, err = arch.Extract(ctx, arch.Request{
+				ArchiveName: ".tmp/test/archive.zip",
+				Directory:   ".tmp/test/dst",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range data {
+				extracted, err := os.ReadFile(filepath.Join(".tmp/test/dst", filepath.Base(name)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(extracted, content) {
+					t.Fatalf("content of %s differs after extraction", name)
+				}
+			}
+		})
+	}
+
+	level := 10
+	_, err := arch.Compress(ctx, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+		Level:       &level,
+	})
+	if !errors.Is(err, arch.ErrInvalidOption) {
+		t.Fatalf("expected ErrInvalidOption for level 10, got %v", err)
+	}
+}
+
+// TestUpdateArchiveLimit makes sure files already in the archive
+// don't count against the limit of appended and updated files
+func TestUpdateArchiveLimit(t *testing.T) {
+	setupTestBasicData(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
+	defer teardownTestBasicData(t)
+	ctx := context.Background()
+	req := arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+		Naming:      arch.NamingRelative,
+	}
+
+	// the default limit of 10 leaves out the two smallest files
+	if _, err := arch.Compress(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	appendReq := req
+	appendReq.Mode = arch.ModeAppend
+	res, err := arch.Compress(ctx, appendReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actEntries := make([]string, 0, len(res.Entries))
+	for _, entry := range res.Entries {
+		actEntries = append(actEntries, entry.Name)
+	}
+	if !fileListsEqual([]string{"two.txt", "one.txt"}, actEntries) || res.Kept != 10 {
+		t.Fatalf("expected two.txt and one.txt added and 10 kept, got %v and %d", actEntries, res.Kept)
+	}
+
+	// four.txt is changed but left out by the limit, so its entry is kept
+	if err := os.WriteFile(files[4].name, []byte("x"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(files[2].name, []byte("xy"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(files[2].name, later, later); err != nil {
+		t.Fatal(err)
+	}
+	updateReq := req
+	updateReq.Mode = arch.ModeUpdate
+	updateReq.Limit = 1
+	res, err = arch.Compress(ctx, updateReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Entries) != 1 || res.Entries[0].Name != "two.txt" || res.Kept != 11 {
+		t.Fatalf("expected two.txt replaced and 11 kept, got %+v and %d", res.Entries, res.Kept)
+	}
+	for _, s := range res.Skipped {
+		if s.Path == files[4].name && s.Reason != arch.SkipLimit {
+			t.Fatalf("expected %s to be skipped by limit, got %s", s.Path, s.Reason)
+		}
+	}
+
+	res, err = arch.Extract(ctx, arch.Request{
+		ArchiveName: req.ArchiveName,
+		Directory:   ".tmp/test/dst",
+		Limit:       100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Files != 12 {
+		t.Fatalf("expected 12 files in updated archive, got %d", res.Files)
+	}
+	for name, exp := range map[string]string{"four.txt": "1234", "two.txt": "xy"} {
+		data, err := os.ReadFile(filepath.Join(".tmp/test/dst", name))
+		if err != nil || string(data) != exp {
+			t.Fatalf("expected %s to be %q, got %q (%v)", name, exp, data, err)
+		}
+	}
+}
+
+func TestUpdateArchive(t *testing.T) {
+	setupTestBasicData(t, []int{1, 2})
+	defer teardownTestBasicData(t)
+	ctx := context.Background()
+	req := arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+		Naming:      arch.NamingRelative,
+	}
+
+	if _, err := arch.Compress(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	base, err := os.ReadFile(req.ArchiveName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// three.txt is new, two.txt is changed, one.txt is touched with the same content
+	setupTestBasicData(t, []int{3})
+	if err := os.WriteFile(files[2].name, []byte("xyz"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(files[1].name, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		mode       string
+		compare    string
+		expEntries []string
+		expKept    int
+		expSkipped map[string]string
+	}{
+		{
+			name:       "append",
+			mode:       arch.ModeAppend,
+			expEntries: []string{"three.txt"},
+			expKept:    2,
+			expSkipped: map[string]string{files[1].name: arch.SkipExists, files[2].name: arch.SkipExists},
+		},
+		{
+			name:       "update by crc",
+			mode:       arch.ModeUpdate,
+			compare:    arch.CompareCRC,
+			expEntries: []string{"three.txt", "two.txt"},
+			expKept:    1,
+			expSkipped: map[string]string{files[1].name: arch.SkipUnchanged},
+		},
+		{
+			name:       "update by mtime",
+			mode:       arch.ModeUpdate,
+			expEntries: []string{"three.txt", "two.txt", "one.txt"},
+			expKept:    0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(req.ArchiveName, base, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			update := req
+			update.Mode = tt.mode
+			update.Compare = tt.compare
+			res, err := arch.Compress(ctx, update)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actEntries := make([]string, 0, len(res.Entries))
+			for _, entry := range res.Entries {
+				actEntries = append(actEntries, entry.Name)
+			}
+			if !fileListsEqual(tt.expEntries, actEntries) || res.Kept != tt.expKept {
+				t.Fatalf("expected %v added and %d kept, got %v and %d", tt.expEntries, tt.expKept, actEntries, res.Kept)
+			}
+			for _, s := range res.Skipped {
+				if tt.expSkipped[s.Path] != s.Reason {
+					t.Fatalf("expected %s to be skipped by %s, got %s", s.Path, tt.expSkipped[s.Path], s.Reason)
+				}
+			}
+
+			res, err = arch.Extract(ctx, arch.Request{
+				ArchiveName: req.ArchiveName,
+				Directory:   ".tmp/test/dst",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Files != 3 {
+				t.Fatalf("expected 3 files in updated archive, got %d", res.Files)
+			}
+			two, err := os.ReadFile(".tmp/test/dst/two.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			expTwo := "xyz"
+			if tt.mode == arch.ModeAppend {
+				expTwo = string(files[2].data)
+			}
+			if string(two) != expTwo {
+				t.Fatalf("expected two.txt to be %q, got %q", expTwo, two)
+			}
+		})
+	}
+
+	if err := os.WriteFile(req.ArchiveName, []byte("not a zip"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	update := req
+	update.Mode = arch.ModeUpdate
+	_, err = arch.Compress(ctx, update)
+	if !errors.Is(err, arch.ErrCorruptArchive) {
+		t.Fatalf("expected ErrCorruptArchive, got %v", err)
+	}
+	data, err := os.ReadFile(req.ArchiveName)
+	if err != nil || string(data) != "not a zip" {
+		t.Fatalf("original archive is changed (%v)", err)
+	}
+	elems, err := os.ReadDir(".tmp/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, elem := range elems {
+		if filepath.Ext(elem.Name()) == ".tmp" {
+			t.Fatalf("temporary file %s is left", elem.Name())
+		}
+	}
+}
+
+func TestAtomicCompress(t *testing.T) {
+	setupTestBasicData(t, []int{1, 2, 3})
+	defer teardownTestBasicData(t)
+	ctx := context.Background()
+	req := arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+	}
+	if _, err := arch.Compress(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	original, err := os.ReadFile(req.ArchiveName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := arch.Compress(canceled, req); !errors.Is(err, arch.ErrCanceled) {
+		t.Fatalf("expected ErrCanceled, got %v", err)
+	}
+
+	// the dangling link is listed but can't be compressed
+	if err := os.Symlink("missing.txt", ".tmp/test/src/dangling.txt"); err != nil {
+		t.Fatal(err)
+	}
+	req.Sort = arch.SortName
+	if _, err := arch.Compress(ctx, req); !errors.Is(err, arch.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	data, err := os.ReadFile(req.ArchiveName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(original, data) {
+		t.Fatal("archive is changed by failed compression")
+	}
+	elems, err := os.ReadDir(".tmp/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, elem := range elems {
+		if filepath.Ext(elem.Name()) == ".tmp" {
+			t.Fatalf("temporary file %s is left", elem.Name())
+		}
+	}
+	info, err := os.Stat(req.ArchiveName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0044 == 0 {
+		t.Fatalf("archive is not readable by others: %v", info.Mode())
+	}
+}
+
+func TestAtomicExtract(t *testing.T) {
+	setupTestBasicData(t, nil)
+	defer teardownTestBasicData(t)
+	ctx := context.Background()
+
+	for name, content := range map[string]string{"a.txt": "alpha content", "b.txt": "bravo content"} {
+		if err := os.WriteFile(filepath.Join(".tmp/test/src", name), []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := arch.Compress(ctx, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+		Method:      arch.MethodStore,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(".tmp/test/archive.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b.txt is stored as is, so changing it breaks its checksum
+	corrupt := bytes.Replace(data, []byte("bravo content"), []byte("bravo CONTENT"), 1)
+	if err := os.WriteFile(".tmp/test/corrupt.zip", corrupt, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		archive string
+		dir     string
+		expErr  error
+		expA    string
+	}{
+		{
+			name:    "failure leaves directory untouched",
+			archive: ".tmp/test/corrupt.zip",
+			dir:     ".tmp/test/dst",
+			expErr:  arch.ErrCorruptArchive,
+			expA:    "old",
+		},
+		{
+			name:    "failure removes created directory",
+			archive: ".tmp/test/corrupt.zip",
+			dir:     ".tmp/test/dst/new",
+			expErr:  arch.ErrCorruptArchive,
+		},
+		{
+			name:    "success",
+			archive: ".tmp/test/archive.zip",
+			dir:     ".tmp/test/dst",
+			expA:    "alpha content",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(".tmp/test/dst/a.txt", []byte("old"), os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			_, err := arch.Extract(ctx, arch.Request{
+				ArchiveName: tt.archive,
+				Directory:   tt.dir,
+				Sort:        arch.SortName,
+				Atomic:      true,
+			})
+			if !errors.Is(err, tt.expErr) {
+				t.Fatalf("expected %v, got %v", tt.expErr, err)
+			}
+			if tt.expA != "" {
+				a, err := os.ReadFile(filepath.Join(tt.dir, "a.txt"))
+				if err != nil || string(a) != tt.expA {
+					t.Fatalf("expected a.txt to be %q, got %q (%v)", tt.expA, a, err)
+				}
+			}
+			if _, err := os.Stat(tt.dir); tt.expErr != nil && tt.dir != ".tmp/test/dst" && !os.IsNotExist(err) {
+				t.Fatalf("directory %s created by failed extraction is left (%v)", tt.dir, err)
+			}
+			elems, err := os.ReadDir(".tmp/test/dst")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, elem := range elems {
+				if elem.IsDir() {
+					t.Fatalf("staging directory %s is left", elem.Name())
+				}
+			}
+		})
+	}
+}
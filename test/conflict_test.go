@@ -0,0 +1,233 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/12z/archivarius/arch"
+)
+
+func TestConflicts(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       string
+		existingTime time.Time
+		expErr       error
+		expContent   map[string]string
+		expAction    string
+	}{
+		{
+			name:       "overwrite by default",
+			expContent: map[string]string{"one.txt": "1"},
+			expAction:  arch.ActionOverwritten,
+		},
+		{
+			name:       "skip",
+			policy:     arch.ConflictSkip,
+			expContent: map[string]string{"one.txt": "old"},
+			expAction:  arch.ActionSkipped,
+		},
+		{
+			name:       "fail",
+			policy:     arch.ConflictFail,
+			expErr:     arch.ErrConflict,
+			expContent: map[string]string{"one.txt": "old"},
+		},
+		{
+			name:       "rename",
+			policy:     arch.ConflictRename,
+			expContent: map[string]string{"one.txt": "old", "one.1.txt": "1"},
+			expAction:  arch.ActionRenamed,
+		},
+		{
+			name:         "newer entry",
+			policy:       arch.ConflictNewer,
+			existingTime: time.Now().Add(-time.Hour),
+			expContent:   map[string]string{"one.txt": "1"},
+			expAction:    arch.ActionOverwritten,
+		},
+		{
+			name:         "newer file",
+			policy:       arch.ConflictNewer,
+			existingTime: time.Now().Add(time.Hour),
+			expContent:   map[string]string{"one.txt": "old"},
+			expAction:    arch.ActionSkipped,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBasicData(t, []int{1, 2})
+			defer teardownTestBasicData(t)
+			ctx := context.Background()
+
+			_, err := arch.Compress(ctx, arch.Request{
+				ArchiveName: ".tmp/test/archive.zip",
+				Directory:   ".tmp/test/src",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			existing := ".tmp/test/dst/one.txt"
+			if err := os.WriteFile(existing, []byte("old"), os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.existingTime.IsZero() {
+				if err := os.Chtimes(existing, tt.existingTime, tt.existingTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			res, err := arch.Extract(ctx, arch.Request{
+				ArchiveName: ".tmp/test/archive.zip",
+				Directory:   ".tmp/test/dst",
+				OnConflict:  tt.policy,
+			})
+			if !errors.Is(err, tt.expErr) {
+				t.Fatalf("expected %v, got %v", tt.expErr, err)
+			}
+			for name, exp := range tt.expContent {
+				data, err := os.ReadFile(".tmp/test/dst/" + name)
+				if err != nil || string(data) != exp {
+					t.Fatalf("expected %s to be %q, got %q (%v)", name, exp, data, err)
+				}
+			}
+			if tt.expErr != nil {
+				return
+			}
+			if len(res.Conflicts) != 1 || res.Conflicts[0].Action != tt.expAction || res.Conflicts[0].Path != existing {
+				t.Fatalf("expected conflict on %s with action %s, got %+v", existing, tt.expAction, res.Conflicts)
+			}
+		})
+	}
+}
+
+func TestConflictResponse(t *testing.T) {
+	srv := setupServer()
+	setupTestBasicData(t, []int{1})
+	defer teardownTestBasicData(t)
+
+	code, resp := postRequest(t, srv, "/api/v1/compress", arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+	})
+	if code != http.StatusOK {
+		t.Fatalf("not 200 response %d (%s)", code, resp.Message)
+	}
+	code, resp = postRequest(t, srv, "/api/v1/extract", arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+		OnConflict:  arch.ConflictFail,
+	})
+	if code != http.StatusConflict || resp.ErrorCode != "conflict" || resp.Path != files[1].name {
+		t.Fatalf("expected 409 conflict on %s, got %d %s on %s", files[1].name, code, resp.ErrorCode, resp.Path)
+	}
+}
+
+// setupDuplicateNames writes an archive of entries a/x.txt and b/x.txt,
+// which are extracted to the same file without keeping paths.
+// a/x.txt is modified later
+func setupDuplicateNames(t *testing.T, data map[string][]byte) {
+	t.Helper()
+	later := time.Now().Add(time.Hour)
+	for name, content := range data {
+		fp := ".tmp/test/src/" + name
+		if err := os.MkdirAll(filepath.Dir(fp), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fp, content, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if name == "a/x.txt" {
+			if err := os.Chtimes(fp, later, later); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	_, err := arch.Compress(context.Background(), arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+		Naming:      arch.NamingRelative,
+		Recursive:   true,
+		Sort:        arch.SortName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConflictsWithinArchive(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		expErr     error
+		expContent map[string]string
+		expAction  string
+	}{
+		{
+			name:       "overwrite by default",
+			expContent: map[string]string{"x.txt": "b"},
+			expAction:  arch.ActionOverwritten,
+		},
+		{
+			name:       "skip",
+			policy:     arch.ConflictSkip,
+			expContent: map[string]string{"x.txt": "a"},
+			expAction:  arch.ActionSkipped,
+		},
+		{
+			name:   "fail",
+			policy: arch.ConflictFail,
+			expErr: arch.ErrConflict,
+		},
+		{
+			name:       "rename",
+			policy:     arch.ConflictRename,
+			expContent: map[string]string{"x.txt": "a", "x.1.txt": "b"},
+			expAction:  arch.ActionRenamed,
+		},
+		{
+			name:       "newer",
+			policy:     arch.ConflictNewer,
+			expContent: map[string]string{"x.txt": "a"},
+			expAction:  arch.ActionSkipped,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBasicData(t, nil)
+			defer teardownTestBasicData(t)
+			setupDuplicateNames(t, map[string][]byte{"a/x.txt": []byte("a"), "b/x.txt": []byte("b")})
+
+			res, err := arch.Extract(context.Background(), arch.Request{
+				ArchiveName: ".tmp/test/archive.zip",
+				Directory:   ".tmp/test/dst",
+				OnConflict:  tt.policy,
+				Concurrency: 1,
+			})
+			if !errors.Is(err, tt.expErr) {
+				t.Fatalf("expected %v, got %v", tt.expErr, err)
+			}
+			if tt.expErr != nil {
+				entries, err := os.ReadDir(".tmp/test/dst")
+				if err != nil || len(entries) != 0 {
+					t.Fatalf("expected nothing extracted, got %d files (%v)", len(entries), err)
+				}
+				return
+			}
+			for name, exp := range tt.expContent {
+				data, err := os.ReadFile(".tmp/test/dst/" + name)
+				if err != nil || string(data) != exp {
+					t.Fatalf("expected %s to be %q, got %q (%v)", name, exp, data, err)
+				}
+			}
+			if len(res.Conflicts) != 1 || res.Conflicts[0].Action != tt.expAction || res.Conflicts[0].Name != "b/x.txt" {
+				t.Fatalf("expected conflict of b/x.txt with action %s, got %+v", tt.expAction, res.Conflicts)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/12z/archivarius/arch"
+	"github.com/12z/archivarius/server"
+)
+
+// compareDirs fails unless every file of src has the same content in dst
+func compareDirs(t *testing.T, src, dst string) {
+	t.Helper()
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		exp, err := os.ReadFile(filepath.Join(src, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		act, err := os.ReadFile(filepath.Join(dst, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(exp, act) {
+			t.Fatalf("extracted %s differs from the original", entry.Name())
+		}
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	tests := []struct {
+		name            string
+		method          string
+		extractPassword string
+		expErr          error
+	}{
+		{
+			name:            "deflate",
+			method:          arch.MethodDeflate,
+			extractPassword: "secret",
+		},
+		{
+			name:            "store",
+			method:          arch.MethodStore,
+			extractPassword: "secret",
+		},
+		{
+			name:            "wrong password",
+			extractPassword: "guess",
+			expErr:          arch.ErrWrongPassword,
+		},
+		{
+			name:   "no password",
+			expErr: arch.ErrWrongPassword,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupParallelData(t, ".tmp/test/src", 4, 100*1024)
+			if err := os.WriteFile(".tmp/test/src/empty.txt", nil, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			defer teardownTestBasicData(t)
+			ctx := context.Background()
+
+			res, err := arch.Compress(ctx, arch.Request{
+				ArchiveName: ".tmp/test/archive.zip",
+				Directory:   ".tmp/test/src",
+				Method:      tt.method,
+				Password:    "secret",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range res.Entries {
+				if !entry.Encrypted {
+					t.Fatalf("expected %s to be encrypted", entry.Name)
+				}
+			}
+
+			res, err = arch.Extract(ctx, arch.Request{
+				ArchiveName: ".tmp/test/archive.zip",
+				Directory:   ".tmp/test/dst",
+				Password:    tt.extractPassword,
+			})
+			if !errors.Is(err, tt.expErr) {
+				t.Fatalf("expected %v, got %v", tt.expErr, err)
+			}
+			if tt.expErr != nil {
+				if code := arch.ErrorCode(err); code != "wrong_password" {
+					t.Fatalf("expected wrong_password code, got %s", code)
+				}
+				return
+			}
+			if res.Files != 5 {
+				t.Fatalf("expected 5 files extracted, got %d", res.Files)
+			}
+			compareDirs(t, ".tmp/test/src", ".tmp/test/dst")
+		})
+	}
+}
+
+func TestEncryptionMixed(t *testing.T) {
+	setupTestBasicData(t, []int{1, 2})
+	defer teardownTestBasicData(t)
+	ctx := context.Background()
+
+	_, err := arch.Compress(ctx, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestBasicData(t, []int{3})
+	_, err = arch.Compress(ctx, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+		Mode:        arch.ModeAppend,
+		Password:    "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := arch.Extract(ctx, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/dst",
+		Password:    "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := map[string]bool{}
+	for _, entry := range res.Entries {
+		encrypted[entry.Name] = entry.Encrypted
+	}
+	if len(encrypted) != 3 || encrypted[files[1].name] || !encrypted[files[3].name] {
+		t.Fatalf("expected only %s encrypted, got %v", files[3].name, encrypted)
+	}
+	compareDirs(t, ".tmp/test/src", ".tmp/test/dst")
+}
+
+func TestEncryptionTampered(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		// offset returns the offset of the byte changed in the archive
+		offset func(f *zip.File) int64
+	}{
+		{
+			name:   "stored data",
+			method: arch.MethodStore,
+			offset: encryptedDataOffset,
+		},
+		{
+			name:   "deflated data",
+			method: arch.MethodDeflate,
+			offset: encryptedDataOffset,
+		},
+		{
+			name:   "stored authentication code",
+			method: arch.MethodStore,
+			offset: authCodeOffset,
+		},
+		{
+			name:   "deflated authentication code",
+			method: arch.MethodDeflate,
+			offset: authCodeOffset,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBasicData(t, []int{12})
+			defer teardownTestBasicData(t)
+			ctx := context.Background()
+
+			_, err := arch.Compress(ctx, arch.Request{
+				ArchiveName: ".tmp/test/archive.zip",
+				Directory:   ".tmp/test/src",
+				Method:      tt.method,
+				Password:    "secret",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader, err := zip.OpenReader(".tmp/test/archive.zip")
+			if err != nil {
+				t.Fatal(err)
+			}
+			offset := tt.offset(reader.File[0])
+			reader.Close()
+			flipByte(t, ".tmp/test/archive.zip", int(offset))
+
+			req := arch.Request{
+				ArchiveName: ".tmp/test/archive.zip",
+				Directory:   ".tmp/test/dst",
+				Password:    "secret",
+			}
+			if _, err := arch.Verify(ctx, req); !errors.Is(err, arch.ErrCorruptArchive) {
+				t.Fatalf("expected %v from verify, got %v", arch.ErrCorruptArchive, err)
+			}
+			if _, err := arch.Extract(ctx, req); !errors.Is(err, arch.ErrCorruptArchive) {
+				t.Fatalf("expected %v from extract, got %v", arch.ErrCorruptArchive, err)
+			}
+			if _, err := os.Stat(".tmp/test/dst/twelve.txt"); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("expected tampered file to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+// encryptedDataOffset returns the offset of the data of the AES-256 entry,
+// which follows the salt and the password verification value
+func encryptedDataOffset(f *zip.File) int64 {
+	offset, _ := f.DataOffset()
+	return offset + 16 + 2
+}
+
+// authCodeOffset returns the offset of the last byte of the authentication
+// code, which ends the data of the entry
+func authCodeOffset(f *zip.File) int64 {
+	offset, _ := f.DataOffset()
+	return offset + int64(f.CompressedSize64) - 1
+}
+
+func TestEncryptionKeyRef(t *testing.T) {
+	setupTestBasicData(t, []int{1, 2})
+	defer teardownTestBasicData(t)
+	if err := os.MkdirAll(".tmp/keys", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(".tmp/keys/customers", []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := server.DefaultConfig()
+	cfg.KeysDir = ".tmp/keys"
+	srv := setupServerWithConfig(cfg)
+
+	code, resp := postRequest(t, srv, "/api/v1/compress", arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+		KeyRef:      "customers",
+	})
+	if code != http.StatusOK {
+		t.Fatalf("not 200 response %d (%s)", code, resp.Message)
+	}
+	code, resp = postRequest(t, srv, "/api/v1/extract", arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/dst",
+		Password:    "secret",
+	})
+	if code != http.StatusOK {
+		t.Fatalf("not 200 response %d (%s)", code, resp.Message)
+	}
+	compareDirs(t, ".tmp/test/src", ".tmp/test/dst")
+
+	for _, ref := range []string{"unknown", "../keys/customers"} {
+		code, resp = postRequest(t, srv, "/api/v1/extract", arch.Request{
+			ArchiveName: ".tmp/test/archive.zip",
+			Directory:   ".tmp/test/dst",
+			KeyRef:      ref,
+		})
+		if code != http.StatusBadRequest || resp.ErrorCode != "invalid_option" {
+			t.Fatalf("expected 400 invalid_option for key %s, got %d %s", ref, code, resp.ErrorCode)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+//go:build !windows
+// +build !windows
+
+package test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/12z/archivarius/arch"
+)
+
+// lowerOpenFiles sets the soft limit of open files to n more than
+// the files open now, restoring the limit on cleanup
+func lowerOpenFiles(t *testing.T, n uint64) uint64 {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		t.Skip(err)
+	}
+	open, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip(err)
+	}
+	lowered := limit
+	lowered.Cur = uint64(len(open)) + n
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lowered); err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() {
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+			t.Error(err)
+		}
+	})
+	return lowered.Cur
+}
+
+func TestOpenFilesLimit(t *testing.T) {
+	const n = 2000
+	if err := os.MkdirAll(".tmp/test/src", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTestBasicData(t)
+	for i := 0; i < n; i++ {
+		name := filepath.Join(".tmp/test/src", fmt.Sprintf("file%04d.txt", i))
+		if err := os.WriteFile(name, []byte(name), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	limit := lowerOpenFiles(t, 32)
+	ctx := context.Background()
+
+	for _, concurrency := range []int{1, 8} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			archive := fmt.Sprintf(".tmp/test/archive%d.zip", concurrency)
+			dst := fmt.Sprintf(".tmp/test/dst%d", concurrency)
+			res, err := arch.Compress(ctx, arch.Request{
+				ArchiveName: archive,
+				Directory:   ".tmp/test/src",
+				Limit:       n,
+				Concurrency: concurrency,
+			})
+			if err != nil || res.Files != n {
+				t.Fatalf("expected %d files compressed with %d open files allowed, got %d (%v)", n, limit, res.Files, err)
+			}
+			res, err = arch.Extract(ctx, arch.Request{
+				ArchiveName: archive,
+				Directory:   dst,
+				Concurrency: concurrency,
+			})
+			if err != nil || res.Files != n {
+				t.Fatalf("expected %d files extracted with %d open files allowed, got %d (%v)", n, limit, res.Files, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/12z/archivarius/arch"
+)
+
+func TestFilters(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      arch.Request
+		files    []int
+		expFiles []int
+		expErr   error
+	}{
+		{
+			name: "globstar include",
+			req: arch.Request{
+				Recursive: true,
+				Include:   []string{"**/*.txt"},
+			},
+			files:    []int{1, 2, 21, 101, 102},
+			expFiles: []int{1, 2, 101, 102},
+		},
+		{
+			name: "include without recursion",
+			req: arch.Request{
+				Include: []string{"**/*.txt"},
+			},
+			files:    []int{1, 2, 21, 101, 102},
+			expFiles: []int{1, 2},
+		},
+		{
+			name: "exclude directory",
+			req: arch.Request{
+				Recursive: true,
+				Include:   []string{"**/*.txt"},
+				Exclude:   []string{"inner/inner/**"},
+			},
+			files:    []int{1, 2, 21, 101, 102},
+			expFiles: []int{1, 2, 101},
+		},
+		{
+			name: "several includes",
+			req: arch.Request{
+				Recursive: true,
+				Include:   []string{"*.json", "inner/*.txt"},
+			},
+			files:    []int{1, 2, 21, 101, 102},
+			expFiles: []int{21, 101},
+		},
+		{
+			name: "regex",
+			req: arch.Request{
+				Recursive: true,
+				Regex:     true,
+				Include:   []string{"^inner/"},
+				Exclude:   []string{"2\\.txt$"},
+			},
+			files:    []int{1, 2, 21, 101, 102},
+			expFiles: []int{101},
+		},
+		{
+			name: "ignore case",
+			req: arch.Request{
+				Filter:     "*.TXT",
+				Exclude:    []string{"ONE.*"},
+				IgnoreCase: true,
+			},
+			files:    []int{1, 2, 21},
+			expFiles: []int{2},
+		},
+		{
+			name: "filter with include",
+			req: arch.Request{
+				Recursive: true,
+				Filter:    "inner*",
+				Include:   []string{"inner/**"},
+			},
+			files:    []int{1, 2, 21, 101, 102},
+			expFiles: []int{101, 102},
+		},
+		{
+			name: "malformed include",
+			req: arch.Request{
+				Include: []string{"[a-"},
+			},
+			expErr: arch.ErrMalformedFilter,
+		},
+		{
+			name: "malformed regex",
+			req: arch.Request{
+				Regex:   true,
+				Exclude: []string{"(a"},
+			},
+			expErr: arch.ErrMalformedFilter,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBasicData(t, tt.files)
+			defer teardownTestBasicData(t)
+
+			req := tt.req
+			req.ArchiveName = ".tmp/test/archive.zip"
+			req.Directory = ".tmp/test/src"
+			res, err := arch.Compress(context.Background(), req)
+			if tt.expErr != nil {
+				if !errors.Is(err, tt.expErr) {
+					t.Fatalf("expected %v, got %v", tt.expErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expNames := make([]string, 0, len(tt.expFiles))
+			for _, i := range tt.expFiles {
+				expNames = append(expNames, files[i].name)
+			}
+			actNames := make([]string, 0, len(res.Entries))
+			for _, entry := range res.Entries {
+				actNames = append(actNames, entry.Path)
+			}
+			if !fileListsEqual(expNames, actNames) {
+				t.Fatalf("wrong files compressed: expected %s, got %s", expNames, actNames)
+			}
+		})
+	}
+}
+
+func TestExtractFilters(t *testing.T) {
+	setupTestBasicData(t, []int{1, 2, 21})
+	defer teardownTestBasicData(t)
+	ctx := context.Background()
+
+	_, err := arch.Compress(ctx, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := arch.Extract(ctx, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/dst",
+		Include:     []string{"**/*.txt"},
+		Exclude:     []string{"**/one.txt"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Files != 1 || res.Entries[0].Name != files[2].name {
+		t.Fatalf("expected only %s extracted, got %+v", files[2].name, res.Entries)
+	}
+}
+
+func TestSizeAndTimeFilters(t *testing.T) {
+	setupTestBasicData(t, []int{1, 2, 3, 4, 5, 6})
+	defer teardownTestBasicData(t)
+	ctx := context.Background()
+
+	// the larger the file the older it is, six.txt is 5 days old
+	now := time.Now()
+	for i, ind := range []int{1, 2, 3, 4, 5, 6} {
+		mtime := now.Add(-time.Duration(i) * 24 * time.Hour)
+		if err := os.Chtimes(files[ind].name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := now.Add(-36 * time.Hour)
+	after := now.Add(-60 * time.Hour)
+
+	res, err := arch.Compress(ctx, arch.Request{
+		ArchiveName:    ".tmp/test/archive.zip",
+		Directory:      ".tmp/test/src",
+		MinSize:        2,
+		MaxSize:        5,
+		ModifiedBefore: &before,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// two.txt is too new, six.txt too large
+	expNames := []string{files[3].name, files[4].name, files[5].name}
+	actNames := make([]string, 0, len(res.Entries))
+	for _, entry := range res.Entries {
+		actNames = append(actNames, entry.Path)
+	}
+	if !fileListsEqual(expNames, actNames) {
+		t.Fatalf("wrong files compressed: expected %s, got %s", expNames, actNames)
+	}
+	reasons := map[string]string{}
+	for _, s := range res.Skipped {
+		reasons[s.Path] = s.Reason
+	}
+	if reasons[files[1].name] != arch.SkipSize || reasons[files[6].name] != arch.SkipSize ||
+		reasons[files[2].name] != arch.SkipModified {
+		t.Fatalf("wrong reasons for skipped files: %v", reasons)
+	}
+
+	res, err = arch.Extract(ctx, arch.Request{
+		ArchiveName:   ".tmp/test/archive.zip",
+		Directory:     ".tmp/test/dst",
+		ModifiedAfter: &after,
+		MaxSize:       4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// five.txt is too large, four.txt is too old
+	if res.Files != 1 || res.Entries[0].Name != files[3].name {
+		t.Fatalf("expected only %s extracted, got %+v", files[3].name, res.Entries)
+	}
+
+	_, err = arch.Compress(ctx, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+		MinSize:     10,
+		MaxSize:     5,
+	})
+	if !errors.Is(err, arch.ErrInvalidOption) {
+		t.Fatalf("expected ErrInvalidOption, got %v", err)
+	}
+}
+
+func TestIgnoreFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		ignores  map[string]string
+		noIgnore bool
+		expFiles []int
+		expErr   error
+	}{
+		{
+			name:     "no ignore files",
+			expFiles: []int{1, 2, 21, 101, 102},
+		},
+		{
+			name:     "pattern matches at any depth",
+			ignores:  map[string]string{"": "# logs\n\n*2.txt\n"},
+			expFiles: []int{1, 2, 21, 101},
+		},
+		{
+			name:     "negation",
+			ignores:  map[string]string{"": "*.txt\n!one.txt\n"},
+			expFiles: []int{1, 21},
+		},
+		{
+			name:     "directory only",
+			ignores:  map[string]string{"": "inner/\nuno.json/\n"},
+			expFiles: []int{1, 2, 21},
+		},
+		{
+			name:     "anchored pattern",
+			ignores:  map[string]string{"": "/inner/*.txt\n"},
+			expFiles: []int{1, 2, 21, 102},
+		},
+		{
+			name: "deeper file takes precedence",
+			ignores: map[string]string{
+				"":            "inner*.txt\n",
+				"inner/inner": "!inner2.txt\n",
+			},
+			expFiles: []int{1, 2, 21, 102},
+		},
+		{
+			name:     "disabled",
+			ignores:  map[string]string{"": "*\n"},
+			noIgnore: true,
+			expFiles: []int{1, 2, 21, 101, 102},
+		},
+		{
+			name:    "malformed pattern",
+			ignores: map[string]string{"": "[a-\n"},
+			expErr:  arch.ErrMalformedFilter,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBasicData(t, []int{1, 2, 21, 101, 102})
+			defer teardownTestBasicData(t)
+			for dir, content := range tt.ignores {
+				name := filepath.Join(".tmp/test/src", dir, arch.IgnoreFileName)
+				if err := os.WriteFile(name, []byte(content), os.ModePerm); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			res, err := arch.Compress(context.Background(), arch.Request{
+				ArchiveName: ".tmp/test/archive.zip",
+				Directory:   ".tmp/test/src",
+				Recursive:   true,
+				NoIgnore:    tt.noIgnore,
+				Exclude:     []string{"**/" + arch.IgnoreFileName},
+			})
+			if tt.expErr != nil {
+				if !errors.Is(err, tt.expErr) {
+					t.Fatalf("expected %v, got %v", tt.expErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expNames := make([]string, 0, len(tt.expFiles))
+			for _, i := range tt.expFiles {
+				expNames = append(expNames, files[i].name)
+			}
+			actNames := make([]string, 0, len(res.Entries))
+			for _, entry := range res.Entries {
+				actNames = append(actNames, entry.Path)
+			}
+			if !fileListsEqual(expNames, actNames) {
+				t.Fatalf("wrong files compressed: expected %s, got %s", expNames, actNames)
+			}
+		})
+	}
+}
+
+func TestSymlinkedDirectory(t *testing.T) {
+	setupTestBasicData(t, []int{1, 2, 21})
+	defer teardownTestBasicData(t)
+	if err := os.Symlink("src", ".tmp/test/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := arch.Compress(context.Background(), arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/link",
+		Recursive:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expNames := []string{
+		strings.Replace(files[1].name, "/src/", "/link/", 1),
+		strings.Replace(files[2].name, "/src/", "/link/", 1),
+		strings.Replace(files[21].name, "/src/", "/link/", 1),
+	}
+	actNames := make([]string, 0, len(res.Entries))
+	for _, entry := range res.Entries {
+		actNames = append(actNames, entry.Path)
+	}
+	if !fileListsEqual(expNames, actNames) {
+		t.Fatalf("wrong files compressed: expected %s, got %s", expNames, actNames)
+	}
+}
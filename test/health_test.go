@@ -0,0 +1,183 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/12z/archivarius/arch"
+	"github.com/12z/archivarius/server"
+)
+
+func TestHealth(t *testing.T) {
+	setupTestBasicData(t, []int{})
+	defer teardownTestBasicData(t)
+
+	writableCfg := server.DefaultConfig()
+	writableCfg.Roots = []string{".tmp/test/src"}
+
+	missingCfg := server.DefaultConfig()
+	missingCfg.Roots = []string{".tmp/test/src", ".tmp/test2/nonexistent"}
+
+	adminCfg := server.DefaultConfig()
+	adminCfg.AdminToken = "secret"
+
+	tests := []struct {
+		name    string
+		cfg     server.Config
+		path    string
+		token   string
+		expCode int
+	}{
+		{
+			name:    "healthz",
+			cfg:     server.DefaultConfig(),
+			path:    "/healthz",
+			expCode: 200,
+		},
+		{
+			name:    "readyz writable roots",
+			cfg:     writableCfg,
+			path:    "/readyz",
+			expCode: 200,
+		},
+		{
+			name:    "readyz missing root",
+			cfg:     missingCfg,
+			path:    "/readyz",
+			expCode: 503,
+		},
+		{
+			name:    "debug disabled",
+			cfg:     server.DefaultConfig(),
+			path:    "/debug/runtime",
+			expCode: 404,
+		},
+		{
+			name:    "debug without token",
+			cfg:     adminCfg,
+			path:    "/debug/runtime",
+			expCode: 401,
+		},
+		{
+			name:    "debug wrong token",
+			cfg:     adminCfg,
+			path:    "/debug/runtime",
+			token:   "wrong",
+			expCode: 401,
+		},
+		{
+			name:    "debug runtime",
+			cfg:     adminCfg,
+			path:    "/debug/runtime",
+			token:   "secret",
+			expCode: 200,
+		},
+		{
+			name:    "debug pprof",
+			cfg:     adminCfg,
+			path:    "/debug/pprof/",
+			token:   "secret",
+			expCode: 200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := setupServerWithConfig(tt.cfg)
+			defer srv.Close()
+
+			req, err := http.NewRequest("GET", srv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.expCode {
+				t.Fatalf("Response code expected %d got %d", tt.expCode, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestReadyMissingSigningKey(t *testing.T) {
+	cfg := server.DefaultConfig()
+	cfg.SigningKey = ".tmp/keys/missing.pem"
+	srv := setupServerWithConfig(cfg)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body server.Response
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Response code expected %d got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+	// the path of the key is not disclosed
+	if strings.Contains(body.Message, "missing.pem") {
+		t.Fatalf("expected generic problem, got %q", body.Message)
+	}
+}
+
+func TestReadyAfterMalformedRequests(t *testing.T) {
+	cfg := server.DefaultConfig()
+	cfg.MaxQueued = 3
+	srv := setupServerWithConfig(cfg)
+	defer srv.Close()
+
+	for i := 0; i < cfg.MaxQueued; i++ {
+		resp, err := http.Post(srv.URL+"/api/v1/compress/async", "application/json", strings.NewReader("{bad"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Response code expected %d got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+	resp, err := http.Get(srv.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Response code expected %d got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestDeletedQueuedSession(t *testing.T) {
+	sm := server.NewSessionManager(server.Config{Workers: 1})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	_, running := sm.CreateSession()
+	go running.Run(arch.Request{}, func(ctx context.Context, req arch.Request) (arch.Result, error) {
+		close(started)
+		<-release
+		return arch.Result{}, nil
+	})
+	defer close(release)
+	<-started
+
+	// the only worker is busy, so the session waits until it is deleted
+	id, queued := sm.CreateSession()
+	sm.Delete(id)
+	processed := false
+	queued.Run(arch.Request{}, func(ctx context.Context, req arch.Request) (arch.Result, error) {
+		processed = true
+		return arch.Result{}, nil
+	})
+	if status, _ := queued.Result(); processed || status != server.Created {
+		t.Fatalf("expected deleted session not to be processed, got status %s", status)
+	}
+}
@@ -0,0 +1,181 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/12z/archivarius/arch"
+)
+
+func TestIncremental(t *testing.T) {
+	setupTestBasicData(t, []int{1, 2, 3})
+	defer teardownTestBasicData(t)
+	ctx := context.Background()
+	req := arch.Request{
+		Directory: ".tmp/test/src",
+		Naming:    arch.NamingRelative,
+	}
+
+	full := req
+	full.ArchiveName = ".tmp/test/full.zip"
+	full.Manifest = true
+	if _, err := arch.Compress(ctx, full); err != nil {
+		t.Fatal(err)
+	}
+
+	// two.txt is changed, three.txt is deleted and four.txt is new
+	if err := os.WriteFile(files[2].name, []byte("changed"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(files[3].name); err != nil {
+		t.Fatal(err)
+	}
+	setupTestBasicData(t, []int{4})
+
+	inc1 := req
+	inc1.ArchiveName = ".tmp/test/inc1.zip"
+	inc1.Incremental = full.ArchiveName
+	res, err := arch.Compress(ctx, inc1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actNames := make([]string, 0, len(res.Entries))
+	for _, entry := range res.Entries {
+		actNames = append(actNames, entry.Name)
+	}
+	if !fileListsEqual([]string{"two.txt", "four.txt"}, actNames) {
+		t.Fatalf("expected changed and new files compressed, got %v", actNames)
+	}
+	if len(res.Deleted) != 1 || res.Deleted[0] != "three.txt" {
+		t.Fatalf("expected three.txt recorded as deleted, got %v", res.Deleted)
+	}
+	if len(res.Skipped) != 1 || res.Skipped[0].Reason != arch.SkipUnchanged {
+		t.Fatalf("expected one.txt skipped as unchanged, got %v", res.Skipped)
+	}
+
+	if err := os.Remove(files[4].name); err != nil {
+		t.Fatal(err)
+	}
+	inc2 := req
+	inc2.ArchiveName = ".tmp/test/inc2.zip"
+	inc2.Incremental = inc1.ArchiveName
+	res, err = arch.Compress(ctx, inc2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Files != 0 || len(res.Deleted) != 1 || res.Deleted[0] != "four.txt" {
+		t.Fatalf("expected only four.txt recorded as deleted, got %+v", res)
+	}
+
+	// three.txt and four.txt are extracted from the base archives
+	// and removed by the incremental ones
+	res, err = arch.Extract(ctx, arch.Request{
+		ArchiveName: inc2.ArchiveName,
+		Chain:       []string{full.ArchiveName, inc1.ArchiveName},
+		Directory:   ".tmp/test/dst",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Deleted) != 2 {
+		t.Fatalf("expected 2 files removed, got %v", res.Deleted)
+	}
+	elems, err := os.ReadDir(".tmp/test/dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := make([]string, 0, len(elems))
+	for _, elem := range elems {
+		restored = append(restored, elem.Name())
+	}
+	if !fileListsEqual([]string{"one.txt", "two.txt"}, restored) {
+		t.Fatalf("expected one.txt and two.txt restored, got %v", restored)
+	}
+	two, err := os.ReadFile(".tmp/test/dst/two.txt")
+	if err != nil || string(two) != "changed" {
+		t.Fatalf("expected changed two.txt restored, got %q (%v)", two, err)
+	}
+
+	_, err = arch.Extract(ctx, arch.Request{
+		ArchiveName: inc2.ArchiveName,
+		Chain:       []string{full.ArchiveName},
+		Directory:   ".tmp/test/dst",
+	})
+	if !errors.Is(err, arch.ErrInvalidOption) {
+		t.Fatalf("expected ErrInvalidOption for broken chain, got %v", err)
+	}
+
+	noManifest := req
+	noManifest.ArchiveName = ".tmp/test/plain.zip"
+	if _, err := arch.Compress(ctx, noManifest); err != nil {
+		t.Fatal(err)
+	}
+	inc1.Incremental = noManifest.ArchiveName
+	_, err = arch.Compress(ctx, inc1)
+	if !errors.Is(err, arch.ErrInvalidOption) {
+		t.Fatalf("expected ErrInvalidOption for base without manifest, got %v", err)
+	}
+}
+
+func TestIncrementalFiltered(t *testing.T) {
+	setupTestBasicData(t, []int{1, 2})
+	defer teardownTestBasicData(t)
+	ctx := context.Background()
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(files[1].name, old, old); err != nil {
+		t.Fatal(err)
+	}
+	req := arch.Request{
+		Directory: ".tmp/test/src",
+		Naming:    arch.NamingRelative,
+	}
+
+	full := req
+	full.ArchiveName = ".tmp/test/full.zip"
+	full.Manifest = true
+	if _, err := arch.Compress(ctx, full); err != nil {
+		t.Fatal(err)
+	}
+
+	// one.txt is left out by the filter, but it is not deleted
+	after := time.Now().Add(-time.Hour)
+	inc := req
+	inc.ArchiveName = ".tmp/test/inc.zip"
+	inc.Incremental = full.ArchiveName
+	inc.ModifiedAfter = &after
+	res, err := arch.Compress(ctx, inc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Deleted) != 0 {
+		t.Fatalf("expected no files recorded as deleted, got %v", res.Deleted)
+	}
+
+	// one.txt is still unchanged for the next incremental archive
+	next := req
+	next.ArchiveName = ".tmp/test/next.zip"
+	next.Incremental = inc.ArchiveName
+	res, err = arch.Compress(ctx, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Files != 0 || len(res.Deleted) != 0 {
+		t.Fatalf("expected nothing changed or deleted, got %+v", res)
+	}
+
+	res, err = arch.Extract(ctx, arch.Request{
+		ArchiveName: next.ArchiveName,
+		Chain:       []string{full.ArchiveName, inc.ArchiveName},
+		Directory:   ".tmp/test/dst",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Deleted) != 0 {
+		t.Fatalf("expected no files removed, got %v", res.Deleted)
+	}
+	compareDirs(t, ".tmp/test/src", ".tmp/test/dst")
+}
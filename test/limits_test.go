@@ -0,0 +1,165 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/12z/archivarius/arch"
+	"github.com/12z/archivarius/server"
+)
+
+// writeTestZip writes an archive with deflated entries of the given contents
+func writeTestZip(t *testing.T, name string, entries map[string][]byte) {
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := zip.NewWriter(file)
+	for entry, data := range entries {
+		w, err := writer.Create(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	// 8MB of zeros deflate about a thousand times
+	bomb := bytes.Repeat([]byte{0}, 8<<20)
+	tests := []struct {
+		name    string
+		entries map[string][]byte
+		limits  arch.ExtractLimits
+		expErr  error
+	}{
+		{
+			name:    "within limits",
+			entries: map[string][]byte{"a/b.txt": []byte("data"), "c.txt": []byte("data")},
+			limits:  arch.ExtractLimits{MaxBytes: 8, MaxEntryBytes: 4, MaxRatio: 10, MaxEntries: 2, MaxDepth: 1},
+		},
+		{
+			name:    "total bytes",
+			entries: map[string][]byte{"b.txt": []byte("data"), "c.txt": []byte("data")},
+			limits:  arch.ExtractLimits{MaxBytes: 7},
+			expErr:  arch.ErrLimitExceeded,
+		},
+		{
+			name:    "entry bytes",
+			entries: map[string][]byte{"b.txt": []byte("data")},
+			limits:  arch.ExtractLimits{MaxEntryBytes: 3},
+			expErr:  arch.ErrLimitExceeded,
+		},
+		{
+			name:    "ratio",
+			entries: map[string][]byte{"bomb.bin": bomb},
+			limits:  arch.ExtractLimits{MaxRatio: 100},
+			expErr:  arch.ErrLimitExceeded,
+		},
+		{
+			name:    "small entries are not checked for ratio",
+			entries: map[string][]byte{"zeros.bin": bomb[:1<<20]},
+			limits:  arch.ExtractLimits{MaxRatio: 100},
+		},
+		{
+			name:    "entries",
+			entries: map[string][]byte{"b.txt": nil, "c.txt": nil, "d.txt": nil},
+			limits:  arch.ExtractLimits{MaxEntries: 2},
+			expErr:  arch.ErrLimitExceeded,
+		},
+		{
+			name:    "depth",
+			entries: map[string][]byte{"a/b/c/d.txt": nil},
+			limits:  arch.ExtractLimits{MaxDepth: 2},
+			expErr:  arch.ErrLimitExceeded,
+		},
+		{
+			name:    "negative limit",
+			entries: map[string][]byte{"b.txt": nil},
+			limits:  arch.ExtractLimits{MaxBytes: -1},
+			expErr:  arch.ErrInvalidOption,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer teardownTestBasicData(t)
+			writeTestZip(t, ".tmp/test/archive.zip", tt.entries)
+
+			res, err := arch.Extract(context.Background(), arch.Request{
+				ArchiveName:   ".tmp/test/archive.zip",
+				Directory:     ".tmp/test/dst",
+				ExtractLimits: tt.limits,
+			})
+			if !errors.Is(err, tt.expErr) {
+				t.Fatalf("expected %v, got %v", tt.expErr, err)
+			}
+			if tt.expErr == nil && res.Files != len(tt.entries) {
+				t.Fatalf("expected %d files, got %d", len(tt.entries), res.Files)
+			}
+			if tt.expErr == arch.ErrLimitExceeded && arch.ErrorCode(err) != "limit_exceeded" {
+				t.Fatalf("expected limit_exceeded code, got %s", arch.ErrorCode(err))
+			}
+		})
+	}
+}
+
+func TestExtractLimitsStreaming(t *testing.T) {
+	defer teardownTestBasicData(t)
+	data := bytes.Repeat([]byte("x"), 1<<20)
+	writeTestZip(t, ".tmp/test/archive.zip", map[string][]byte{"one.bin": data, "two.bin": data})
+
+	// every entry is within the limit, so the total is exceeded
+	// while the second one is extracted
+	_, err := arch.Extract(context.Background(), arch.Request{
+		ArchiveName:   ".tmp/test/archive.zip",
+		Directory:     ".tmp/test/dst",
+		ExtractLimits: arch.ExtractLimits{MaxBytes: 3 << 19},
+		Concurrency:   1,
+	})
+	if !errors.Is(err, arch.ErrLimitExceeded) {
+		t.Fatalf("expected %v, got %v", arch.ErrLimitExceeded, err)
+	}
+	entries, err := os.ReadDir(".tmp/test/dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected partially extracted file to be removed, got %d files", len(entries))
+	}
+}
+
+func TestExtractLimitsConfig(t *testing.T) {
+	cfg := server.DefaultConfig()
+	if cfg.ExtractLimits.MaxBytes <= 0 {
+		t.Fatal("extracted bytes are not limited by default")
+	}
+	cfg.ExtractLimits.MaxEntryBytes = 3
+	srv := setupServerWithConfig(cfg)
+	defer teardownTestBasicData(t)
+	writeTestZip(t, ".tmp/test/archive.zip", map[string][]byte{"b.txt": []byte("data")})
+
+	// the request can't loosen the configured limit
+	code, resp := postRequest(t, srv, "/api/v1/extract", arch.Request{
+		ArchiveName:   ".tmp/test/archive.zip",
+		Directory:     ".tmp/test/dst",
+		ExtractLimits: arch.ExtractLimits{MaxEntryBytes: 100},
+	})
+	if code != http.StatusBadRequest || resp.ErrorCode != "limit_exceeded" || resp.Entry != "b.txt" {
+		t.Fatalf("expected 400 limit_exceeded on b.txt, got %d %s on %s", code, resp.ErrorCode, resp.Entry)
+	}
+}
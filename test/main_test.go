@@ -52,6 +52,9 @@ func TestBasic(t *testing.T) {
 		compLimit int
 		extrLimit int
 
+		compSort string
+		extrSort string
+
 		files    []int
 		expFiles []int
 	}{
@@ -104,6 +107,34 @@ func TestBasic(t *testing.T) {
 			files:     []int{5, 6, 7, 8, 9},
 			expFiles:  []int{7, 8, 9},
 		},
+		{
+			name:      "compress sort size asc",
+			compLimit: 3,
+			compSort:  arch.SortSizeAsc,
+			files:     []int{5, 6, 7, 8},
+			expFiles:  []int{5, 6, 7},
+		},
+		{
+			name:      "compress sort name",
+			compLimit: 2,
+			compSort:  arch.SortName,
+			files:     []int{1, 2, 3, 4},
+			expFiles:  []int{4, 1},
+		},
+		{
+			name:      "extract sort name",
+			extrLimit: 2,
+			extrSort:  arch.SortName,
+			files:     []int{5, 6, 7, 8},
+			expFiles:  []int{8, 5},
+		},
+		{
+			name:      "extract sort size asc",
+			extrLimit: 2,
+			extrSort:  arch.SortSizeAsc,
+			files:     []int{5, 6, 7, 8},
+			expFiles:  []int{5, 6},
+		},
 	}
 
 	for _, tt := range tests {
@@ -123,6 +154,7 @@ func TestBasic(t *testing.T) {
 				Directory:   ".tmp/test/src/",
 				Filter:      tt.compFilter,
 				Limit:       tt.compLimit,
+				Sort:        tt.compSort,
 			}
 
 			compReqData, err := json.Marshal(compReq)
@@ -145,6 +177,7 @@ func TestBasic(t *testing.T) {
 				Directory:   ".tmp/test/dst",
 				Filter:      tt.extrFilter,
 				Limit:       tt.extrLimit,
+				Sort:        tt.extrSort,
 			}
 
 			extReqData, err := json.Marshal(extReq)
@@ -208,6 +241,7 @@ func TestBasic(t *testing.T) {
 				Directory:   ".tmp/test/src/",
 				Filter:      tt.compFilter,
 				Limit:       tt.compLimit,
+				Sort:        tt.compSort,
 			}
 
 			compReqData, err := json.Marshal(compReq)
@@ -268,6 +302,7 @@ func TestBasic(t *testing.T) {
 				Directory:   ".tmp/test/dst",
 				Filter:      tt.extrFilter,
 				Limit:       tt.extrLimit,
+				Sort:        tt.extrSort,
 			}
 
 			extReqData, err := json.Marshal(extReq)
@@ -358,10 +393,11 @@ func TestBasic(t *testing.T) {
 
 func TestCompressNegative(t *testing.T) {
 	tests := []struct {
-		name    string
-		req     arch.Request
-		expCode int
-		expResp []byte
+		name       string
+		req        arch.Request
+		expCode    int
+		expErrCode string
+		expResp    []byte
 	}{
 		{
 			name: "nonexistent source dir",
@@ -369,7 +405,8 @@ func TestCompressNegative(t *testing.T) {
 				ArchiveName: ".tmp/test/archive.zip",
 				Directory:   ".tmp/test2/src/",
 			},
-			expCode: 400,
+			expCode:    400,
+			expErrCode: "not_found",
 		},
 		{
 			name: "no rights to create archive",
@@ -380,8 +417,9 @@ func TestCompressNegative(t *testing.T) {
 			expCode: 400,
 		},
 		{
-			name:    "empty data in request",
-			expCode: 400,
+			name:       "empty data in request",
+			expCode:    400,
+			expErrCode: "not_found",
 		},
 	}
 	for _, tt := range tests {
@@ -410,6 +448,7 @@ func TestCompressNegative(t *testing.T) {
 			if tt.expCode != compResp.StatusCode {
 				t.Fatalf("Response code expected %d got %d", tt.expCode, compResp.StatusCode)
 			}
+			checkErrorCode(t, compResp, tt.expErrCode)
 		})
 	}
 	t.Run("illegal compression request", func(t *testing.T) {
@@ -467,10 +506,11 @@ func TestCompressNegative(t *testing.T) {
 
 func TestExtractNegative(t *testing.T) {
 	tests := []struct {
-		name    string
-		req     arch.Request
-		expCode int
-		expResp []byte
+		name       string
+		req        arch.Request
+		expCode    int
+		expErrCode string
+		expResp    []byte
 	}{
 		{
 			name: "nonexistent archive",
@@ -478,7 +518,18 @@ func TestExtractNegative(t *testing.T) {
 				ArchiveName: ".tmp/test2/archive.zip",
 				Directory:   ".tmp/test/dst",
 			},
-			expCode: 400,
+			expCode:    400,
+			expErrCode: "not_found",
+		},
+		{
+			name: "malformed filter",
+			req: arch.Request{
+				ArchiveName: ".tmp/test/archive.zip",
+				Directory:   ".tmp/test/dst",
+				Filter:      "[",
+			},
+			expCode:    400,
+			expErrCode: "malformed_filter",
 		},
 		{
 			name: "no rights to create archive",
@@ -489,8 +540,9 @@ func TestExtractNegative(t *testing.T) {
 			expCode: 400,
 		},
 		{
-			name:    "empty data in request",
-			expCode: 400,
+			name:       "empty data in request",
+			expCode:    400,
+			expErrCode: "not_found",
 		},
 	}
 	for _, tt := range tests {
@@ -539,6 +591,7 @@ func TestExtractNegative(t *testing.T) {
 			if tt.expCode != extResp.StatusCode {
 				t.Fatalf("Response code expected %d response %d", tt.expCode, extResp.StatusCode)
 			}
+			checkErrorCode(t, extResp, tt.expErrCode)
 		})
 	}
 	t.Run("illegal extraction request", func(t *testing.T) {
@@ -592,6 +645,7 @@ func TestExtractNegative(t *testing.T) {
 		if extResp.StatusCode != 400 {
 			t.Fatalf("Response code expected %d response %d", 400, extResp.StatusCode)
 		}
+		checkErrorCode(t, extResp, "not_found")
 	})
 	t.Run("non POST extraction request", func(t *testing.T) {
 		srv := setupServer()
@@ -616,8 +670,12 @@ func TestExtractNegative(t *testing.T) {
 }
 
 func setupServer() *httptest.Server {
-	sm := server.NewSessionManager()
-	mux := server.Router(sm)
+	return setupServerWithConfig(server.DefaultConfig())
+}
+
+func setupServerWithConfig(cfg server.Config) *httptest.Server {
+	sm := server.NewSessionManager(cfg)
+	mux := server.Router(sm, cfg)
 	testServer := httptest.NewServer(mux)
 	return testServer
 }
@@ -640,6 +698,24 @@ func setupTestBasicData(t *testing.T, filesInd []int) {
 	}
 }
 
+// checkErrorCode checks error_code of the response if expCode is not empty
+func checkErrorCode(t *testing.T, httpResp *http.Response, expCode string) {
+	if expCode == "" {
+		return
+	}
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp server.Response
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ErrorCode != expCode {
+		t.Fatalf("Error code expected %s got %s (%s)", expCode, resp.ErrorCode, resp.Message)
+	}
+}
+
 func fileListsEqual(exp, act []string) bool {
 	if len(exp) != len(act) {
 		return false
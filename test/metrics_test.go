@@ -0,0 +1,68 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/12z/archivarius/arch"
+)
+
+func TestMetrics(t *testing.T) {
+	srv := setupServer()
+	setupTestBasicData(t, []int{1, 2, 3})
+	defer teardownTestBasicData(t)
+	serverUrl, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compReqData, err := json.Marshal(arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverUrl.Path = "/api/v1/compress"
+	compResp, err := http.Post(
+		serverUrl.String(), "application/json", bytes.NewReader(compReqData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compResp.StatusCode != 200 {
+		t.Fatalf("not 200 response %d", compResp.StatusCode)
+	}
+
+	serverUrl.Path = "/metrics"
+	metricsResp, err := http.Get(serverUrl.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metricsResp.StatusCode != 200 {
+		t.Fatalf("not 200 response %d", metricsResp.StatusCode)
+	}
+	data, err := io.ReadAll(metricsResp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(data)
+
+	expLines := []string{
+		"# TYPE archivarius_http_requests_total counter",
+		`archivarius_http_requests_total{endpoint="/api/v1/compress",method="POST",code="200"}`,
+		`archivarius_http_request_duration_seconds_bucket{endpoint="/api/v1/compress",method="POST",code="200",le="+Inf"}`,
+		`archivarius_files_processed_total{operation="compress"}`,
+		"archivarius_compressed_bytes_total ",
+	}
+	for _, line := range expLines {
+		if !strings.Contains(body, line) {
+			t.Fatalf("metrics output does not contain %q:\n%s", line, body)
+		}
+	}
+}
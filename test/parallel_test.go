@@ -0,0 +1,139 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/12z/archivarius/arch"
+)
+
+// setupParallelData writes n files of size bytes, half random
+// and half repeated, to dir
+func setupParallelData(tb testing.TB, dir string, n, size int) {
+	tb.Helper()
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		tb.Fatal(err)
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		data := make([]byte, size)
+		rnd.Read(data[:size/2])
+		copy(data[size/2:], bytes.Repeat([]byte(fmt.Sprintf("line %d\n", i)), size/2))
+		name := filepath.Join(dir, fmt.Sprintf("file%02d.bin", i))
+		if err := os.WriteFile(name, data, os.ModePerm); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+func TestParallelCompression(t *testing.T) {
+	setupParallelData(t, ".tmp/test/src", 20, 64*1024)
+	defer teardownTestBasicData(t)
+	ctx := context.Background()
+
+	// concurrency 1 compresses files one by one as Compress did before
+	// files were compressed concurrently, archives made by the pool
+	// are compared with that serial one
+	levels := []int{1, 4, 16}
+	var archives [][]byte
+	for _, concurrency := range levels {
+		name := fmt.Sprintf(".tmp/test/archive%d.zip", concurrency)
+		_, err := arch.Compress(ctx, arch.Request{
+			ArchiveName: name,
+			Directory:   ".tmp/test/src",
+			Limit:       20,
+			Concurrency: concurrency,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archives = append(archives, data)
+	}
+	for i := 1; i < len(archives); i++ {
+		if !bytes.Equal(archives[0], archives[i]) {
+			t.Fatalf("archive of concurrency %d differs from the serial one", levels[i])
+		}
+	}
+
+	res, err := arch.Compress(ctx, arch.Request{
+		ArchiveName:     ".tmp/test/archive.zip",
+		Directory:       ".tmp/test/src",
+		Limit:           20,
+		Concurrency:     8,
+		MaxArchiveBytes: 5 * 64 * 1024,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Files == 0 || res.Files+len(res.Skipped) != 20 {
+		t.Fatalf("expected archive budget to leave out some of 20 files, got %d files and %d skipped",
+			res.Files, len(res.Skipped))
+	}
+}
+
+func TestParallelExtractionDuplicates(t *testing.T) {
+	setupTestBasicData(t, nil)
+	defer teardownTestBasicData(t)
+	// the earlier entry takes much longer to extract than the later one
+	setupDuplicateNames(t, map[string][]byte{
+		"a/x.txt": bytes.Repeat([]byte("A"), 3<<20),
+		"b/x.txt": []byte("short"),
+	})
+
+	for i := 0; i < 10; i++ {
+		if err := os.RemoveAll(".tmp/test/dst"); err != nil {
+			t.Fatal(err)
+		}
+		res, err := arch.Extract(context.Background(), arch.Request{
+			ArchiveName: ".tmp/test/archive.zip",
+			Directory:   ".tmp/test/dst",
+			Concurrency: 8,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(".tmp/test/dst/x.txt")
+		if err != nil || string(data) != "short" {
+			t.Fatalf("expected the later entry to be extracted, got %d bytes (%v)", len(data), err)
+		}
+		if len(res.Entries) != 1 || len(res.Conflicts) != 1 || res.Conflicts[0].Action != arch.ActionOverwritten {
+			t.Fatalf("expected one entry extracted over the other, got %+v and %+v", res.Entries, res.Conflicts)
+		}
+	}
+}
+
+func BenchmarkCompress(b *testing.B) {
+	dir := filepath.Join(b.TempDir(), "src")
+	setupParallelData(b, dir, 32, 1024*1024)
+	archive := filepath.Join(b.TempDir(), "archive.zip")
+
+	for _, concurrency := range []int{1, 2, 4, 8, 0} {
+		name := fmt.Sprintf("concurrency=%d", concurrency)
+		if concurrency == 0 {
+			name = "concurrency=numcpu"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(32 * 1024 * 1024)
+			for i := 0; i < b.N; i++ {
+				_, err := arch.Compress(context.Background(), arch.Request{
+					ArchiveName: archive,
+					Directory:   dir,
+					Limit:       32,
+					Concurrency: concurrency,
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/12z/archivarius/arch"
+	"github.com/12z/archivarius/server"
+)
+
+func TestResult(t *testing.T) {
+	srv := setupServer()
+	setupTestBasicData(t, []int{1, 2, 3, 4, 5, 21, 101})
+	defer teardownTestBasicData(t)
+
+	code, resp := postRequest(t, srv, "/api/v1/compress", arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+		Filter:      "*.txt",
+		Limit:       3,
+	})
+	if code != 200 {
+		t.Fatalf("not 200 response %d (%s)", code, resp.Message)
+	}
+	res := resp.Result
+	if res == nil {
+		t.Fatal("no result in response")
+	}
+	expEntries := []string{
+		".tmp/test/src/five.txt",
+		".tmp/test/src/four.txt",
+		".tmp/test/src/three.txt",
+	}
+	if len(res.Entries) != len(expEntries) {
+		t.Fatalf("expected %d entries, got %d", len(expEntries), len(res.Entries))
+	}
+	for i, entry := range res.Entries {
+		if entry.Path != expEntries[i] {
+			t.Fatalf("expected entry %d to be %s, got %s", i, expEntries[i], entry.Path)
+		}
+		if entry.CompressedSize == 0 {
+			t.Fatalf("no compressed size for %s", entry.Path)
+		}
+	}
+	if res.Files != 3 || res.Bytes != 12 {
+		t.Fatalf("expected 3 files of 12 bytes, got %d files of %d bytes", res.Files, res.Bytes)
+	}
+	if res.Elapsed == 0 {
+		t.Fatal("no elapsed time in result")
+	}
+
+	expSkipped := map[string]string{
+		".tmp/test/src/inner":    arch.SkipDirectory,
+		".tmp/test/src/uno.json": arch.SkipFilter,
+		".tmp/test/src/two.txt":  arch.SkipLimit,
+		".tmp/test/src/one.txt":  arch.SkipLimit,
+	}
+	if len(res.Skipped) != len(expSkipped) {
+		t.Fatalf("expected %d skipped files, got %v", len(expSkipped), res.Skipped)
+	}
+	for _, s := range res.Skipped {
+		if expSkipped[s.Path] != s.Reason {
+			t.Fatalf("expected %s to be skipped by %s, got %s", s.Path, expSkipped[s.Path], s.Reason)
+		}
+	}
+}
+
+// postRequest posts req to path of the test server and decodes the response
+func postRequest(t *testing.T, srv *httptest.Server, path string, req interface{}) (int, server.Response) {
+	t.Helper()
+	reqData, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpResp, err := http.Post(srv.URL+path, "application/json", bytes.NewReader(reqData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp server.Response
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return httpResp.StatusCode, resp
+}
+
+func TestDryRun(t *testing.T) {
+	srv := setupServer()
+	setupTestBasicData(t, []int{1, 2, 3, 4, 5, 21})
+	defer teardownTestBasicData(t)
+
+	code, resp := postRequest(t, srv, "/api/v1/compress", arch.Request{
+		ArchiveName: ".tmp/test/dry.zip",
+		Directory:   ".tmp/test/src",
+		Filter:      "*.txt",
+		Limit:       2,
+		DryRun:      true,
+	})
+	if code != 200 {
+		t.Fatalf("not 200 response %d (%s)", code, resp.Message)
+	}
+	if _, err := os.Stat(".tmp/test/dry.zip"); !os.IsNotExist(err) {
+		t.Fatalf("archive is created in dry run mode (%v)", err)
+	}
+	res := resp.Result
+	if !res.DryRun || res.Files != 2 || res.Bytes != 9 {
+		t.Fatalf("expected dry run of 2 files of 9 bytes, got %+v", res)
+	}
+	for _, entry := range res.Entries {
+		if entry.CompressedSize == 0 {
+			t.Fatalf("compressed size of %s is not estimated", entry.Path)
+		}
+	}
+
+	code, resp = postRequest(t, srv, "/api/v1/compress", arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+	})
+	if code != 200 {
+		t.Fatalf("not 200 response %d (%s)", code, resp.Message)
+	}
+
+	code, resp = postRequest(t, srv, "/api/v1/extract", arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/dst",
+		Filter:      "*.json",
+		DryRun:      true,
+	})
+	if code != 200 {
+		t.Fatalf("not 200 response %d (%s)", code, resp.Message)
+	}
+	res = resp.Result
+	if !res.DryRun || res.Files != 1 || res.Entries[0].Path != filepath.Join(".tmp/test/dst", "uno.json") {
+		t.Fatalf("expected dry run of uno.json, got %+v", res)
+	}
+	elems, err := os.ReadDir(".tmp/test/dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elems) != 0 {
+		t.Fatalf("files are extracted in dry run mode: %v", elems)
+	}
+}
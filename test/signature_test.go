@@ -0,0 +1,289 @@
+package test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/12z/archivarius/arch"
+	"github.com/12z/archivarius/server"
+)
+
+func newSigningKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// flipByte changes a byte of the file at offset from its start,
+// or from its end if offset is negative
+func flipByte(t *testing.T, name string, offset int) {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset < 0 {
+		offset += len(data)
+	}
+	data[offset] ^= 1
+	if err := os.WriteFile(name, data, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	key := newSigningKey(t)
+	other := newSigningKey(t)
+
+	tests := []struct {
+		name      string
+		sign      string
+		verifyKey ed25519.PublicKey
+		tamper    func(t *testing.T)
+		expErr    error
+	}{
+		{
+			name: "detached",
+			sign: arch.SignDetached,
+		},
+		{
+			name: "comment",
+			sign: arch.SignComment,
+		},
+		{
+			name:      "public key",
+			sign:      arch.SignComment,
+			verifyKey: key.Public().(ed25519.PublicKey),
+		},
+		{
+			name:      "other key",
+			sign:      arch.SignDetached,
+			verifyKey: other.Public().(ed25519.PublicKey),
+			expErr:    arch.ErrInvalidSignature,
+		},
+		{
+			name:   "unsigned",
+			expErr: arch.ErrInvalidSignature,
+		},
+		{
+			name:   "tampered archive",
+			sign:   arch.SignDetached,
+			tamper: func(t *testing.T) { flipByte(t, ".tmp/test/archive.zip", 40) },
+			expErr: arch.ErrInvalidSignature,
+		},
+		{
+			name:   "tampered signature",
+			sign:   arch.SignDetached,
+			tamper: func(t *testing.T) { flipByte(t, ".tmp/test/archive.zip"+arch.SignatureExt, 0) },
+			expErr: arch.ErrInvalidSignature,
+		},
+		{
+			name:   "tampered central directory",
+			sign:   arch.SignComment,
+			tamper: func(t *testing.T) { flipByte(t, ".tmp/test/archive.zip", -150) },
+			expErr: arch.ErrInvalidSignature,
+		},
+		{
+			name:   "tampered comment",
+			sign:   arch.SignComment,
+			tamper: func(t *testing.T) { flipByte(t, ".tmp/test/archive.zip", -1) },
+			expErr: arch.ErrInvalidSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBasicData(t, []int{1, 2, 3})
+			defer teardownTestBasicData(t)
+			ctx := context.Background()
+
+			res, err := arch.Compress(ctx, arch.Request{
+				ArchiveName: ".tmp/test/archive.zip",
+				Directory:   ".tmp/test/src",
+				Sign:        tt.sign,
+				SigningKey:  key,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Signed != (tt.sign != "") {
+				t.Fatalf("expected signed %v, got %v", tt.sign != "", res.Signed)
+			}
+			if _, err := os.Stat(".tmp/test/archive.zip" + arch.SignatureExt); (err == nil) != (tt.sign == arch.SignDetached) {
+				t.Fatalf("unexpected detached signature (%v)", err)
+			}
+			if tt.tamper != nil {
+				tt.tamper(t)
+			}
+
+			req := arch.Request{
+				ArchiveName:      ".tmp/test/archive.zip",
+				Directory:        ".tmp/test/dst",
+				RequireSignature: true,
+				VerifyKey:        tt.verifyKey,
+			}
+			if tt.verifyKey == nil {
+				req.SigningKey = key
+			}
+			_, err = arch.Verify(ctx, req)
+			if !errors.Is(err, tt.expErr) {
+				t.Fatalf("expected %v from verify, got %v", tt.expErr, err)
+			}
+			res, err = arch.Extract(ctx, req)
+			if !errors.Is(err, tt.expErr) {
+				t.Fatalf("expected %v from extract, got %v", tt.expErr, err)
+			}
+			if tt.expErr == nil {
+				if !res.Signed {
+					t.Fatal("expected signature to be checked")
+				}
+				compareDirs(t, ".tmp/test/src", ".tmp/test/dst")
+				return
+			}
+			// nothing is written unless the signature matches
+			entries, err := os.ReadDir(".tmp/test/dst")
+			if err != nil || len(entries) != 0 {
+				t.Fatalf("expected nothing extracted, got %d files (%v)", len(entries), err)
+			}
+		})
+	}
+}
+
+func TestSignatureOptions(t *testing.T) {
+	setupTestBasicData(t, []int{1})
+	defer teardownTestBasicData(t)
+	ctx := context.Background()
+
+	for _, req := range []arch.Request{
+		{Sign: "inline", SigningKey: newSigningKey(t)},
+		{Sign: arch.SignDetached},
+	} {
+		req.ArchiveName = ".tmp/test/archive.zip"
+		req.Directory = ".tmp/test/src"
+		if _, err := arch.Compress(ctx, req); !errors.Is(err, arch.ErrInvalidOption) {
+			t.Fatalf("expected %v for sign %q, got %v", arch.ErrInvalidOption, req.Sign, err)
+		}
+	}
+
+	if _, err := arch.Compress(ctx, arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := arch.Extract(ctx, arch.Request{
+		ArchiveName:      ".tmp/test/archive.zip",
+		Directory:        ".tmp/test/dst",
+		RequireSignature: true,
+	})
+	if !errors.Is(err, arch.ErrInvalidOption) {
+		t.Fatalf("expected %v without a key, got %v", arch.ErrInvalidOption, err)
+	}
+}
+
+func TestSignatureServer(t *testing.T) {
+	setupTestBasicData(t, []int{1, 2})
+	defer teardownTestBasicData(t)
+
+	srv := setupServer()
+	httpResp, err := http.Get(srv.URL + "/api/v1/public-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 without a signing key, got %d", httpResp.StatusCode)
+	}
+	code, resp := postRequest(t, srv, "/api/v1/compress", arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+		Sign:        arch.SignDetached,
+	})
+	if code != http.StatusBadRequest || resp.ErrorCode != "invalid_option" {
+		t.Fatalf("expected 400 invalid_option without a signing key, got %d %s", code, resp.ErrorCode)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(newSigningKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(".tmp/keys", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(".tmp/keys/signing.pem", keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := server.DefaultConfig()
+	cfg.SigningKey = ".tmp/keys/signing.pem"
+	srv = setupServerWithConfig(cfg)
+
+	code, resp = postRequest(t, srv, "/api/v1/compress", arch.Request{
+		ArchiveName: ".tmp/test/archive.zip",
+		Directory:   ".tmp/test/src",
+		Sign:        arch.SignDetached,
+	})
+	if code != http.StatusOK || !resp.Result.Signed {
+		t.Fatalf("expected signed archive, got %d (%s)", code, resp.Message)
+	}
+	code, resp = postRequest(t, srv, "/api/v1/extract", arch.Request{
+		ArchiveName:      ".tmp/test/archive.zip",
+		Directory:        ".tmp/test/dst",
+		RequireSignature: true,
+	})
+	if code != http.StatusOK {
+		t.Fatalf("not 200 response %d (%s)", code, resp.Message)
+	}
+	compareDirs(t, ".tmp/test/src", ".tmp/test/dst")
+
+	// consumers verify the signature offline with the published key
+	httpResp, err = http.Get(srv.URL + "/api/v1/public-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil || httpResp.StatusCode != http.StatusOK {
+		t.Fatalf("expected public key, got %d (%v)", httpResp.StatusCode, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatalf("public key is not PEM: %s", data)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := os.ReadFile(".tmp/test/archive.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := os.ReadFile(".tmp/test/archive.zip" + arch.SignatureExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha512.Sum512(archive)
+	if !ed25519.Verify(pub.(ed25519.PublicKey), digest[:], signature) {
+		t.Fatal("signature doesn't match the published key")
+	}
+
+	flipByte(t, ".tmp/test/archive.zip", 40)
+	code, resp = postRequest(t, srv, "/api/v1/verify", arch.Request{
+		ArchiveName:      ".tmp/test/archive.zip",
+		RequireSignature: true,
+	})
+	if code != http.StatusBadRequest || resp.ErrorCode != "invalid_signature" {
+		t.Fatalf("expected 400 invalid_signature, got %d %s", code, resp.ErrorCode)
+	}
+}
@@ -0,0 +1,224 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"errors"
+	"hash/crc32"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/12z/archivarius/arch"
+)
+
+// zipCryptoEntry is an entry of a test archive,
+// encrypted with ZipCrypto unless password is empty
+type zipCryptoEntry struct {
+	name     string
+	data     []byte
+	password string
+}
+
+// zipCryptoKeys encrypts data as traditional PKWARE encryption does
+type zipCryptoKeys [3]uint32
+
+func newZipCryptoKeys(password string) *zipCryptoKeys {
+	keys := &zipCryptoKeys{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(password); i++ {
+		keys.update(password[i])
+	}
+	return keys
+}
+
+func (k *zipCryptoKeys) update(b byte) {
+	crc := func(crc uint32, b byte) uint32 {
+		return crc32.IEEETable[byte(crc)^b] ^ crc>>8
+	}
+	k[0] = crc(k[0], b)
+	k[1] = (k[1]+k[0]&0xff)*134775813 + 1
+	k[2] = crc(k[2], byte(k[1]>>24))
+}
+
+func (k *zipCryptoKeys) encrypt(p []byte) {
+	for i, c := range p {
+		temp := k[2] | 2
+		p[i] = c ^ byte(temp*(temp^1)>>8)
+		k.update(c)
+	}
+}
+
+// writeZipCrypto writes an archive of deflated entries
+func writeZipCrypto(t *testing.T, name string, entries []zipCryptoEntry) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	for _, entry := range entries {
+		var data bytes.Buffer
+		fw, _ := flate.NewWriter(&data, flate.DefaultCompression)
+		fw.Write(entry.data)
+		fw.Close()
+		header := &zip.FileHeader{
+			Name:               entry.name,
+			Method:             zip.Deflate,
+			CRC32:              crc32.ChecksumIEEE(entry.data),
+			UncompressedSize64: uint64(len(entry.data)),
+		}
+		raw := data.Bytes()
+		if entry.password != "" {
+			header.Flags |= 0x1
+			encHeader := []byte("random bytes")
+			encHeader[11] = byte(header.CRC32 >> 24)
+			keys := newZipCryptoKeys(entry.password)
+			keys.encrypt(encHeader)
+			keys.encrypt(raw)
+			raw = append(encHeader, raw...)
+		}
+		header.CompressedSize64 = uint64(len(raw))
+		w, err := writer.CreateRaw(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, archive.Bytes(), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var zipCryptoEntries = []zipCryptoEntry{
+	{name: "secret.txt", data: bytes.Repeat([]byte("secret data\n"), 1000), password: "partner"},
+	{name: "public.txt", data: []byte("public data")},
+}
+
+func TestZipCrypto(t *testing.T) {
+	tests := []struct {
+		name     string
+		process  func(context.Context, arch.Request) (arch.Result, error)
+		password string
+		expErr   error
+	}{
+		{
+			name:     "extract",
+			process:  arch.Extract,
+			password: "partner",
+		},
+		{
+			name:     "extract with wrong password",
+			process:  arch.Extract,
+			password: "guess",
+			expErr:   arch.ErrWrongPassword,
+		},
+		{
+			name:    "list without password",
+			process: arch.List,
+		},
+		{
+			name:     "list with wrong password",
+			process:  arch.List,
+			password: "guess",
+			expErr:   arch.ErrWrongPassword,
+		},
+		{
+			name:     "verify",
+			process:  arch.Verify,
+			password: "partner",
+		},
+		{
+			name:    "verify without password",
+			process: arch.Verify,
+			expErr:  arch.ErrWrongPassword,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer teardownTestBasicData(t)
+			writeZipCrypto(t, ".tmp/test/archive.zip", zipCryptoEntries)
+
+			res, err := tt.process(context.Background(), arch.Request{
+				ArchiveName: ".tmp/test/archive.zip",
+				Directory:   ".tmp/test/dst",
+				Password:    tt.password,
+			})
+			if !errors.Is(err, tt.expErr) {
+				t.Fatalf("expected %v, got %v", tt.expErr, err)
+			}
+			if tt.expErr != nil {
+				return
+			}
+			if len(res.Entries) != len(zipCryptoEntries) {
+				t.Fatalf("expected %d entries, got %d", len(zipCryptoEntries), len(res.Entries))
+			}
+			for i, entry := range res.Entries {
+				exp := zipCryptoEntries[i]
+				if entry.Name != exp.name || entry.Encrypted != (exp.password != "") || entry.Size != int64(len(exp.data)) {
+					t.Fatalf("unexpected entry %+v", entry)
+				}
+				if entry.Path == "" {
+					continue
+				}
+				data, err := os.ReadFile(entry.Path)
+				if err != nil || !bytes.Equal(data, exp.data) {
+					t.Fatalf("extracted %s differs from the original (%v)", entry.Name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	defer teardownTestBasicData(t)
+	ctx := context.Background()
+
+	// the first entry is deflated, so flipping a byte
+	// breaks either the deflate stream or the checksum
+	writeZipCrypto(t, ".tmp/test/archive.zip", zipCryptoEntries)
+	data, err := os.ReadFile(".tmp/test/archive.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[30+len("secret.txt")+20] ^= 1
+	if err := os.WriteFile(".tmp/test/broken.zip", data, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	_, err = arch.Verify(ctx, arch.Request{ArchiveName: ".tmp/test/broken.zip", Password: "partner"})
+	if !errors.Is(err, arch.ErrCorruptArchive) {
+		t.Fatalf("expected %v, got %v", arch.ErrCorruptArchive, err)
+	}
+
+	setupTestBasicData(t, []int{1, 2, 3})
+	_, err = arch.Compress(ctx, arch.Request{
+		ArchiveName: ".tmp/test/aes.zip",
+		Directory:   ".tmp/test/src",
+		Password:    "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := arch.Verify(ctx, arch.Request{ArchiveName: ".tmp/test/aes.zip", Password: "secret"})
+	if err != nil || res.Files != 3 {
+		t.Fatalf("expected 3 files verified, got %d (%v)", res.Files, err)
+	}
+
+	srv := setupServer()
+	for _, path := range []string{"/api/v1/list", "/api/v1/verify"} {
+		code, resp := postRequest(t, srv, path, arch.Request{
+			ArchiveName: ".tmp/test/aes.zip",
+			Password:    "secret",
+		})
+		if code != http.StatusOK || resp.Result.Files != 3 {
+			t.Fatalf("expected 200 with 3 files from %s, got %d (%s)", path, code, resp.Message)
+		}
+	}
+}